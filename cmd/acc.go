@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trains-io/z21.go"
+)
+
+const (
+	DEFAULT_TURNOUT_PULSE time.Duration = 100 * time.Millisecond
+
+	TURNOUT_DIVERGING uint8 = 0x00 // P=0, output 1
+	TURNOUT_STRAIGHT  uint8 = 0x01 // P=1, output 2
+	TURNOUT_ACTIVATE  uint8 = 0x08 // A=1
+
+	TURNOUT_MAX_ADDRESS uint16 = 2048 // 11 bits of Adr_MSB and Adr_LSB
+)
+
+var accCmd = &cobra.Command{
+	Use:     "acc",
+	Aliases: []string{"accessory"},
+	Short:   "Switch accessory decoders",
+}
+
+// ---------- subcommands ----------
+
+// set ADDR straight|diverging
+var accSetCmd = &cobra.Command{
+	Use:   "set ADDR straight|diverging",
+	Short: "Switch a turnout",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		addr, err := parseTurnoutAddress(args[0])
+		if err != nil {
+			return err
+		}
+		pos, err := parseTurnoutPosition(args[1])
+		if err != nil {
+			return err
+		}
+
+		app := GetAppContext(cmd)
+		if app == nil || app.Conn == nil {
+			return fmt.Errorf("Z21 connection not initialized")
+		}
+
+		if err := setTurnout(app, addr, pos); err != nil {
+			return err
+		}
+		fmt.Printf("Turnout %d set to %s.\n", addr, formatTurnoutPosition(pos))
+		return nil
+	},
+}
+
+// setTurnout switches a turnout: the output is activated, and deactivated
+// again after a short pulse.
+func setTurnout(app *AppContext, addr uint16, pos uint8) error {
+	if _, err := Req(app.Conn, &turnoutSet{Address: addr, Output: pos | TURNOUT_ACTIVATE}); err != nil {
+		return err
+	}
+	time.Sleep(DEFAULT_TURNOUT_PULSE)
+	_, err := Req(app.Conn, &turnoutSet{Address: addr, Output: pos})
+	return err
+}
+
+func parseTurnoutAddress(s string) (uint16, error) {
+	val, err := strconv.ParseUint(s, 0, 16)
+	if err != nil || val == 0 || val > uint64(TURNOUT_MAX_ADDRESS) {
+		return 0, fmt.Errorf("invalid turnout address %q (1-%d)", s, TURNOUT_MAX_ADDRESS)
+	}
+	return uint16(val), nil
+}
+
+func parseTurnoutPosition(s string) (uint8, error) {
+	switch strings.ToLower(s) {
+	case "straight", "s":
+		return TURNOUT_STRAIGHT, nil
+	case "diverging", "d":
+		return TURNOUT_DIVERGING, nil
+	default:
+		return 0, fmt.Errorf("invalid turnout position %q, expected straight or diverging", s)
+	}
+}
+
+func formatTurnoutPosition(pos uint8) string {
+	if pos == TURNOUT_STRAIGHT {
+		return "straight"
+	}
+	return "diverging"
+}
+
+// ---------- messages ----------
+
+// LAN_X_SET_TURNOUT
+type turnoutSet struct {
+	Address uint16 // 1-based
+	Output  uint8  // 0000A00P
+}
+
+func (m *turnoutSet) Pack() ([]byte, error) {
+	if m.Address == 0 || m.Address > TURNOUT_MAX_ADDRESS {
+		return nil, fmt.Errorf("invalid turnout address %d (1-%d)", m.Address, TURNOUT_MAX_ADDRESS)
+	}
+	addr := m.Address - 1
+	b := []byte{z21.LAN_X_SET_TURNOUT, byte(addr >> 8), byte(addr), 0x80 | m.Output}
+	return append(b, xor(b)), nil
+}
+
+func (m *turnoutSet) Unpack(data []byte) error {
+	return nil
+}
+
+func (m *turnoutSet) EncapType() uint16 {
+	return z21.LAN_X
+}
+
+func (m *turnoutSet) Key() (string, bool) {
+	return "", false
+}
+
+// ---------- init ----------
+
+func init() {
+	accCmd.AddCommand(
+		accSetCmd,
+	)
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/trains-io/z21.go"
+)
+
+func TestParseTurnoutAddress(t *testing.T) {
+	tests := []struct {
+		s    string
+		want uint16
+		err  bool
+	}{
+		{s: "1", want: 1},
+		{s: "2048", want: 2048},
+		{s: "0x10", want: 16},
+		{s: "0", err: true},
+		{s: "2049", err: true},
+		{s: "65535", err: true},
+		{s: "-1", err: true},
+		{s: "yard", err: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseTurnoutAddress(tt.s)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseTurnoutAddress(%q) = %d, want error", tt.s, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseTurnoutAddress(%q) = %d, %v, want %d", tt.s, got, err, tt.want)
+		}
+	}
+}
+
+func TestTurnoutSetPack(t *testing.T) {
+	got, err := (&turnoutSet{Address: 2048, Output: TURNOUT_STRAIGHT | TURNOUT_ACTIVATE}).Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{z21.LAN_X_SET_TURNOUT, 0x07, 0xff, 0x89}
+	want = append(want, xor(want))
+	if !bytes.Equal(got, want) {
+		t.Errorf("Pack = % x, want % x", got, want)
+	}
+
+	for _, addr := range []uint16{0, 2049} {
+		if _, err := (&turnoutSet{Address: addr}).Pack(); err == nil {
+			t.Errorf("Pack of address %d succeeded, want error", addr)
+		}
+	}
+}
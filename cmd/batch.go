@@ -0,0 +1,215 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// batch FILE|- [--stop-on-error]
+var batchCmd = &cobra.Command{
+	Use:   "batch FILE|-",
+	Short: "Run subcommands from a file on a single connection",
+	Long: `Run subcommands from a file, one per line, on a single Z21 connection.
+Use - to read the commands from stdin. Empty lines and lines starting with
+# are skipped, "sleep DURATION" pauses the batch:
+
+  power on
+  acc set 12 diverging
+  sleep 500ms
+  loco drive 3 --speed 40
+  route set "Yard 1"`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		stopOnError, _ := cmd.Flags().GetBool("stop-on-error")
+
+		app := GetAppContext(cmd)
+		if app == nil || app.Conn == nil {
+			return fmt.Errorf("Z21 connection not initialized")
+		}
+
+		var r io.Reader = os.Stdin
+		if args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+
+		failed := 0
+		n := 0
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			n++
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+
+			if err := runBatchLine(app, line); err != nil {
+				if stopOnError {
+					return fmt.Errorf("line %d: %s: %w", n, line, err)
+				}
+				fmt.Fprintf(os.Stderr, "Error: line %d: %s: %s\n", n, line, err)
+				failed++
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+
+		if failed > 0 {
+			return fmt.Errorf("%d command(s) failed", failed)
+		}
+		return nil
+	},
+}
+
+func runBatchLine(app *AppContext, line string) error {
+	args, err := splitCommandLine(line)
+	if err != nil {
+		return err
+	}
+
+	if args[0] == "sleep" {
+		if len(args) != 2 {
+			return fmt.Errorf("expected sleep DURATION")
+		}
+		d, err := time.ParseDuration(args[1])
+		if err != nil {
+			return err
+		}
+		time.Sleep(d)
+		return nil
+	}
+
+	return execCommand(app, args)
+}
+
+// longRunningCommands run until they are interrupted or read from stdin,
+// they are not run from batches, rules or the shell.
+var longRunningCommands map[*cobra.Command]bool
+
+// findCommand returns the subcommand of args and its remaining arguments.
+func findCommand(args []string) (*cobra.Command, []string, error) {
+	c, rest, err := rootCmd.Find(args)
+	if err != nil {
+		return nil, nil, err
+	}
+	if c == rootCmd || c.RunE == nil {
+		return nil, nil, fmt.Errorf("unknown command %q", strings.Join(args, " "))
+	}
+	if longRunningCommands[c] {
+		return nil, nil, fmt.Errorf("%q cannot be run from batches, rules or the shell", c.CommandPath())
+	}
+	return c, rest, nil
+}
+
+// execCommand runs a CLI subcommand on the connection of app.
+func execCommand(app *AppContext, args []string) error {
+	c, rest, err := findCommand(args)
+	if err != nil {
+		return err
+	}
+
+	// flags keep their values from the previous run
+	c.Flags().VisitAll(func(f *pflag.Flag) {
+		if !f.Changed {
+			return
+		}
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			sv.Replace(nil)
+		} else {
+			f.Value.Set(f.DefValue)
+		}
+		f.Changed = false
+	})
+	if err := c.ParseFlags(rest); err != nil {
+		return err
+	}
+	if f := c.Flags().Lookup("watch"); f != nil && f.Changed {
+		return fmt.Errorf("%q --watch cannot be run from batches, rules or the shell", c.CommandPath())
+	}
+	rest = c.Flags().Args()
+	if err := c.ValidateArgs(rest); err != nil {
+		return err
+	}
+
+	c.SetContext(context.WithValue(context.Background(), appCtxKey, app))
+	return c.RunE(c, rest)
+}
+
+// splitCommandLine splits a line into arguments at white space. Single and
+// double quotes group arguments containing white space.
+func splitCommandLine(line string) ([]string, error) {
+	args := []string{}
+	var arg strings.Builder
+	var quote rune
+	inArg := false
+
+	for _, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in %q", line)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+	return args, nil
+}
+
+// ---------- init ----------
+
+func init() {
+	longRunningCommands = map[*cobra.Command]bool{}
+	for _, c := range []*cobra.Command{
+		monitorCmd,
+		onCmd,
+		shellCmd,
+		serveCmd,
+		batchCmd,
+		bridgeMqttCmd,
+		exporterCmd,
+		canWatchCmd,
+		clockWatchCmd,
+		railcomWatchCmd,
+		layoutWatchCmd,
+	} {
+		longRunningCommands[c] = true
+	}
+
+	batchCmd.Flags().Bool("stop-on-error", false, "stop at the first failing command")
+}
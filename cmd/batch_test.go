@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSplitCommandLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+		err  bool
+	}{
+		{line: "power on", want: []string{"power", "on"}},
+		{line: "  loco drive   3  --speed 40 ", want: []string{"loco", "drive", "3", "--speed", "40"}},
+		{line: "acc\tset\t12 \tdiverging", want: []string{"acc", "set", "12", "diverging"}},
+		{line: `route set "Yard 1"`, want: []string{"route", "set", "Yard 1"}},
+		{line: `route set 'Yard "A"'`, want: []string{"route", "set", `Yard "A"`}},
+		{line: `can name 0xDB04 Yard" "1`, want: []string{"can", "name", "0xDB04", "Yard 1"}},
+		{line: `route add ""`, want: []string{"route", "add", ""}},
+		{line: `route set "Yard 1`, err: true},
+		{line: "", err: true},
+		{line: " \t ", err: true},
+	}
+
+	for _, tt := range tests {
+		got, err := splitCommandLine(tt.line)
+		if tt.err {
+			if err == nil {
+				t.Errorf("splitCommandLine(%q) = %q, want error", tt.line, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("splitCommandLine(%q) failed: %s", tt.line, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("splitCommandLine(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestExecCommandLongRunning(t *testing.T) {
+	for _, args := range [][]string{
+		{"monitor"},
+		{"on", "short_circuit", "do", "power", "off"},
+		{"shell"},
+		{"serve"},
+		{"batch", "-"},
+		{"can", "watch"},
+		{"status", "system", "--watch"},
+	} {
+		err := execCommand(&AppContext{}, args)
+		if err == nil || !strings.Contains(err.Error(), "cannot be run") {
+			t.Errorf("execCommand(%q) = %v, want long-running command error", args, err)
+		}
+	}
+}
+
+func TestExecCommandAllowed(t *testing.T) {
+	// fails on the missing connection, not on the deny-list
+	err := execCommand(&AppContext{}, []string{"status", "system"})
+	if err == nil || strings.Contains(err.Error(), "cannot be run") {
+		t.Errorf("execCommand(status system) = %v, want connection error", err)
+	}
+}
@@ -0,0 +1,228 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+	"github.com/trains-io/z21.go"
+)
+
+var canBlockCmd = &cobra.Command{
+	Use:   "block",
+	Short: "Name the sections of CAN detectors",
+}
+
+// ---------- subcommands ----------
+
+// set NETID:PORT NAME
+var canBlockSetCmd = &cobra.Command{
+	Use:   "set NETID:PORT NAME",
+	Short: "Name the section of a CAN detector port",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		netid, port, err := parseCanPort(args[0])
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSpace(args[1])
+		if name == "" {
+			return fmt.Errorf("block name must not be empty")
+		}
+
+		c, err := loadCurrentContext()
+		if err != nil {
+			return err
+		}
+
+		key := formatCanPort(netid, port)
+		err = updateContext(c.Name, func(c *ContextInfo) {
+			if c.CanBlocks == nil {
+				c.CanBlocks = map[string]string{}
+			}
+			c.CanBlocks[key] = name
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Block %s = %q\n", key, name)
+		return nil
+	},
+}
+
+// rm NETID:PORT
+var canBlockRmCmd = &cobra.Command{
+	Use:   "rm NETID:PORT",
+	Short: "Remove the name of a CAN detector port",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		netid, port, err := parseCanPort(args[0])
+		if err != nil {
+			return err
+		}
+
+		c, err := loadCurrentContext()
+		if err != nil {
+			return err
+		}
+
+		key := formatCanPort(netid, port)
+		if _, ok := c.CanBlocks[key]; !ok {
+			return fmt.Errorf("block %s not found", key)
+		}
+
+		err = updateContext(c.Name, func(c *ContextInfo) {
+			delete(c.CanBlocks, key)
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Block %s removed\n", key)
+		return nil
+	},
+}
+
+// ls [--json]
+var canBlockLsCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "List the named sections of CAN detectors",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		asJSON, _ := cmd.Flags().GetBool("json")
+
+		c, err := loadCurrentContext()
+		if err != nil {
+			return err
+		}
+
+		keys := sortedCanPorts(c.CanBlocks)
+		if asJSON {
+			blocks := []canBlockReport{}
+			for _, k := range keys {
+				netid, port, _ := parseCanPort(k)
+				blocks = append(blocks, canBlockReport{
+					NetworkID: netid,
+					Port:      int(port) + 1,
+					Name:      c.CanBlocks[k],
+				})
+			}
+			return printJSON(blocks)
+		}
+
+		if len(keys) == 0 {
+			fmt.Println("No blocks named")
+			return nil
+		}
+
+		t := table.NewWriter()
+		t.SetOutputMirror(os.Stdout)
+		t.Style().Options.DrawBorder = false
+		t.Style().Options.SeparateColumns = false
+		t.AppendHeader(table.Row{"Port", "Block"})
+		for _, k := range keys {
+			t.AppendRow(table.Row{k, c.CanBlocks[k]})
+		}
+		t.Render()
+		return nil
+	},
+}
+
+type canBlockReport struct {
+	NetworkID uint16 `json:"netid"`
+	Port      int    `json:"port"`
+	Name      string `json:"name"`
+}
+
+// parseCanPort parses NETID:PORT, the port is counted from 1 as shown by
+// `can info`. It returns the port index counted from 0.
+func parseCanPort(s string) (uint16, uint8, error) {
+	id, port, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid port %q, expected NETID:PORT", s)
+	}
+	netid, err := parseNetID(id)
+	if err != nil {
+		return 0, 0, err
+	}
+	p, err := strconv.ParseUint(port, 10, 8)
+	if err != nil || p == 0 {
+		return 0, 0, fmt.Errorf("invalid port %q, expected NETID:PORT", s)
+	}
+	return netid, uint8(p - 1), nil
+}
+
+// formatCanPort returns the NETID:PORT key of a port index.
+func formatCanPort(netid uint16, index uint8) string {
+	return fmt.Sprintf("0x%04X:%s", netid, formatPortIndex(index))
+}
+
+func sortedCanPorts(blocks map[string]string) []string {
+	keys := []string{}
+	for k := range blocks {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, pa, _ := parseCanPort(keys[i])
+		b, pb, _ := parseCanPort(keys[j])
+		if a != b {
+			return a < b
+		}
+		return pa < pb
+	})
+	return keys
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// printJSONLine prints v as a single line of JSON, as used by the watch
+// commands.
+func printJSONLine(v any) error {
+	return json.NewEncoder(os.Stdout).Encode(v)
+}
+
+// printCanDetectorLine prints a LAN_CAN_DETECTOR message for the monitor.
+func printCanDetectorLine(v *z21.CanDetector, blocks map[string]string) {
+	block := blocks[formatCanPort(v.NetworkID, v.Port)]
+	if block != "" {
+		block = fmt.Sprintf("(%s)", block)
+	}
+
+	switch {
+	case v.Type == z21.CANMessageTypeStatus:
+		fmt.Printf("[CAN] NetID: 0x%04X Port: %-2s %-18s %s\n",
+			v.NetworkID, formatPortIndex(v.Port), formatPortStatus(v.Value1), block)
+	case v.Type >= CAN_MESSAGE_TYPE_RAILCOM_FIRST && v.Type <= CAN_MESSAGE_TYPE_RAILCOM_LAST:
+		locos := formatCanLocos([]canLoco{newCanLoco(v.Value1), newCanLoco(v.Value2)})
+		if locos == "" {
+			return
+		}
+		fmt.Printf("[CAN] NetID: 0x%04X Port: %-2s %-18s %s\n",
+			v.NetworkID, formatPortIndex(v.Port), "loco "+locos, block)
+	}
+}
+
+// ---------- init ----------
+
+func init() {
+	canBlockCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error { return nil }
+	canBlockCmd.PersistentPostRun = func(cmd *cobra.Command, args []string) {}
+	canCmd.AddCommand(canBlockCmd)
+	canBlockCmd.AddCommand(
+		canBlockSetCmd,
+		canBlockRmCmd,
+		canBlockLsCmd,
+	)
+
+	canBlockLsCmd.Flags().Bool("json", false, "print the blocks as JSON")
+}
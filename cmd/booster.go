@@ -0,0 +1,350 @@
+package cmd
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+	"github.com/trains-io/z21.go"
+)
+
+const (
+	BOOSTER_POWER_OFF uint8 = 0x00
+	BOOSTER_POWER_ON  uint8 = 0xFF
+)
+
+const (
+	BOOSTER_STATE_BG_ACTIVE         uint16 = 0x0001 // brake generator
+	BOOSTER_STATE_SHORT_CIRCUIT     uint16 = 0x0020
+	BOOSTER_STATE_TRACK_VOLTAGE_OFF uint16 = 0x0080
+	BOOSTER_STATE_RAILCOM_ACTIVE    uint16 = 0x0100
+	BOOSTER_STATE_OUTPUT_DISABLED   uint16 = 0x0200
+)
+
+var boosterCmd = &cobra.Command{
+	Use:   "booster",
+	Short: "Manage CAN boosters",
+}
+
+// ---------- subcommands ----------
+
+// ls [--timeout | -t SECONDS]
+var boosterLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List CAN boosters",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+
+		app := GetAppContext(cmd)
+		if app == nil || app.Conn == nil {
+			return fmt.Errorf("Z21 connection not initialized")
+		}
+
+		fmt.Printf("Discover CAN boosters (timeout: %s) ...\n", timeout)
+		boosters, err := getBoosterStates(app, 0, timeout)
+		if err != nil {
+			return err
+		}
+		printBoosters(app, boosters)
+		return nil
+	},
+}
+
+// status NETID [--timeout | -t SECONDS]
+var boosterStatusCmd = &cobra.Command{
+	Use:   "status NETID",
+	Short: "Show the state of the outputs of a CAN booster",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+
+		netid, err := parseNetID(args[0])
+		if err != nil {
+			return err
+		}
+
+		app := GetAppContext(cmd)
+		if app == nil || app.Conn == nil {
+			return fmt.Errorf("Z21 connection not initialized")
+		}
+
+		boosters, err := getBoosterStates(app, netid, timeout)
+		if err != nil {
+			return err
+		}
+		printBoosterStatus(netid, boosters[netid])
+		return nil
+	},
+}
+
+// power NETID on|off [--port N]
+var boosterPowerCmd = &cobra.Command{
+	Use:       "power NETID on|off",
+	Short:     "Turn the track power of a CAN booster on or off",
+	Args:      cobra.ExactArgs(2),
+	ValidArgs: []string{"on", "off"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		port, _ := cmd.Flags().GetUint8("port")
+
+		netid, err := parseNetID(args[0])
+		if err != nil {
+			return err
+		}
+
+		var on bool
+		switch args[1] {
+		case "on":
+			on = true
+		case "off":
+			on = false
+		default:
+			return fmt.Errorf("invalid power state %q, expected on or off", args[1])
+		}
+
+		app := GetAppContext(cmd)
+		if app == nil || app.Conn == nil {
+			return fmt.Errorf("Z21 connection not initialized")
+		}
+
+		return setBoosterPower(app, netid, port, on)
+	},
+}
+
+func parseNetID(s string) (uint16, error) {
+	val, err := strconv.ParseUint(s, 0, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid NetID %q", s)
+	}
+	return uint16(val), nil
+}
+
+// getBoosterStates collects the booster state messages until timeout. A
+// netid of 0 collects the state of all boosters.
+func getBoosterStates(app *AppContext, netid uint16, timeout time.Duration) (map[uint16][]*boosterState, error) {
+	if err := subscribe(app.Conn, z21.CAN_BOOSTER_UPDATES); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	boosters := map[uint16][]*boosterState{}
+	for {
+		select {
+		case <-ctx.Done():
+			return boosters, nil
+		case f := <-app.Frames:
+			if f.Header != z21.LAN_CAN_BOOSTER_SYSTEMSTATE_CHGD {
+				continue
+			}
+			st := &boosterState{}
+			if err := st.Unpack(f.Payload); err != nil {
+				continue
+			}
+			if netid != 0 && st.NetworkID != netid {
+				continue
+			}
+			boosters[st.NetworkID] = mergeBoosterState(boosters[st.NetworkID], st)
+		}
+	}
+}
+
+// mergeBoosterState keeps the latest state per output, ordered by output.
+func mergeBoosterState(outputs []*boosterState, st *boosterState) []*boosterState {
+	for i, o := range outputs {
+		if o.OutputPort == st.OutputPort {
+			outputs[i] = st
+			return outputs
+		}
+	}
+	outputs = append(outputs, st)
+	sort.Slice(outputs, func(i, j int) bool {
+		return outputs[i].OutputPort < outputs[j].OutputPort
+	})
+	return outputs
+}
+
+// setBoosterPower switches all outputs of a booster, or only the given output
+// port (1 or 2) of a dual booster, and waits for the booster to report it.
+func setBoosterPower(app *AppContext, netid uint16, port uint8, on bool) error {
+	if port > 2 {
+		return fmt.Errorf("invalid output port %d (1-2)", port)
+	}
+
+	if err := subscribe(app.Conn, z21.CAN_BOOSTER_UPDATES); err != nil {
+		return err
+	}
+
+	msg := &boosterSetTrackPower{NetworkID: netid, Power: BOOSTER_POWER_OFF}
+	switch {
+	case port != 0:
+		msg.Power = port << 4
+		if on {
+			msg.Power |= 0x01
+		}
+	case on:
+		msg.Power = BOOSTER_POWER_ON
+	}
+
+	_, err := ReqFrame(app, msg, DEFAULT_REQ_TIMEOUT, func(f *z21.Frame) bool {
+		st := &boosterState{}
+		if f.Header != z21.LAN_CAN_BOOSTER_SYSTEMSTATE_CHGD || st.Unpack(f.Payload) != nil {
+			return false
+		}
+		return st.NetworkID == netid &&
+			(port == 0 || st.OutputPort == uint16(port)) &&
+			st.trackOn() == on
+	})
+	if err != nil {
+		return fmt.Errorf("booster 0x%04X did not report the power change: %w", netid, err)
+	}
+
+	state := "off"
+	if on {
+		state = "on"
+	}
+	fmt.Printf("Booster 0x%04X track power is turned %s.\n", netid, state)
+	return nil
+}
+
+func printBoosters(app *AppContext, boosters map[uint16][]*boosterState) {
+	if len(boosters) == 0 {
+		fmt.Printf("No boosters found\n")
+		return
+	}
+
+	ids := []uint16{}
+	for id := range boosters {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.Style().Options.DrawBorder = false
+	t.Style().Options.SeparateColumns = false
+	t.AppendHeader(table.Row{"NetID", "Name", "Outputs", "Current"})
+	for _, id := range ids {
+		name, err := getCanDescription(app, id)
+		if err != nil || name == "" {
+			name = "-"
+		}
+		var current uint32
+		for _, o := range boosters[id] {
+			current += uint32(o.Current)
+		}
+		t.AppendRow(
+			table.Row{
+				fmt.Sprintf("0x%04X", id),
+				name,
+				fmt.Sprintf("%d", len(boosters[id])),
+				fmt.Sprintf("%dmA", current),
+			},
+		)
+	}
+	t.Render()
+}
+
+func printBoosterStatus(netid uint16, outputs []*boosterState) {
+	if len(outputs) == 0 {
+		fmt.Printf("Booster not found\n")
+		return
+	}
+
+	fmt.Printf("Booster: 0x%04X\n", netid)
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.Style().Options.DrawBorder = false
+	t.Style().Options.SeparateColumns = false
+	t.AppendHeader(table.Row{"Output", "Track", "Short", "RailCom", "Brake Gen.", "Voltage", "Current"})
+	for _, o := range outputs {
+		t.AppendRow(
+			table.Row{
+				fmt.Sprintf("%d", o.OutputPort),
+				formatBoosterTrack(o),
+				formatOnOff(o.State&BOOSTER_STATE_SHORT_CIRCUIT != 0),
+				formatOnOff(o.State&BOOSTER_STATE_RAILCOM_ACTIVE != 0),
+				formatOnOff(o.State&BOOSTER_STATE_BG_ACTIVE != 0),
+				fmt.Sprintf("%.1fV", float64(o.VccVoltage)/1000),
+				fmt.Sprintf("%dmA", o.Current),
+			},
+		)
+	}
+	t.Render()
+}
+
+func formatBoosterTrack(o *boosterState) string {
+	if o.State&BOOSTER_STATE_OUTPUT_DISABLED != 0 {
+		return "disabled"
+	}
+	return formatOnOff(o.trackOn())
+}
+
+// ---------- messages ----------
+
+// LAN_CAN_BOOSTER_SYSTEMSTATE_CHGD
+type boosterState struct {
+	NetworkID  uint16
+	OutputPort uint16
+	State      uint16
+	VccVoltage uint16 // mV
+	Current    uint16 // mA
+}
+
+func (m *boosterState) Unpack(data []byte) error {
+	if len(data) < 10 {
+		return fmt.Errorf("truncated booster state")
+	}
+	m.NetworkID = binary.LittleEndian.Uint16(data[0:])
+	m.OutputPort = binary.LittleEndian.Uint16(data[2:])
+	m.State = binary.LittleEndian.Uint16(data[4:])
+	m.VccVoltage = binary.LittleEndian.Uint16(data[6:])
+	m.Current = binary.LittleEndian.Uint16(data[8:])
+	return nil
+}
+
+func (m *boosterState) trackOn() bool {
+	return m.State&BOOSTER_STATE_TRACK_VOLTAGE_OFF == 0
+}
+
+// LAN_CAN_BOOSTER_SET_TRACKPOWER
+type boosterSetTrackPower struct {
+	NetworkID uint16
+	Power     uint8
+}
+
+func (m *boosterSetTrackPower) Pack() ([]byte, error) {
+	return z21.PackFields(m.NetworkID, m.Power)
+}
+
+func (m *boosterSetTrackPower) Unpack(data []byte) error {
+	return nil
+}
+
+func (m *boosterSetTrackPower) EncapType() uint16 {
+	return z21.LAN_CAN_BOOSTER_SET_TRACKPOWER
+}
+
+func (m *boosterSetTrackPower) Key() (string, bool) {
+	return "", false
+}
+
+// ---------- init ----------
+
+func init() {
+	boosterCmd.AddCommand(
+		boosterLsCmd,
+		boosterStatusCmd,
+		boosterPowerCmd,
+	)
+
+	boosterLsCmd.Flags().DurationP("timeout", "t", DEFAULT_SCAN_TIMEOUT, "timeout in seconds")
+	boosterStatusCmd.Flags().DurationP("timeout", "t", DEFAULT_SCAN_TIMEOUT, "timeout in seconds")
+	boosterPowerCmd.Flags().Uint8("port", 0, "output port of a dual booster (1-2), default all")
+}
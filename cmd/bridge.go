@@ -0,0 +1,338 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/spf13/cobra"
+	"github.com/trains-io/z21.go"
+)
+
+const (
+	DEFAULT_MQTT_BROKER    string        = "tcp://localhost:1883"
+	DEFAULT_MQTT_CLIENT_ID string        = "z21cli"
+	MQTT_CONNECT_TIMEOUT   time.Duration = 10 * time.Second
+
+	MQTT_STATUS_ONLINE  string = "online"
+	MQTT_STATUS_OFFLINE string = "offline"
+)
+
+var bridgeCmd = &cobra.Command{
+	Use:   "bridge",
+	Short: "Bridge the Z21 to other systems",
+}
+
+// ---------- subcommands ----------
+
+// mqtt [--broker URL] [--topic PREFIX]
+var bridgeMqttCmd = &cobra.Command{
+	Use:   "mqtt",
+	Short: "Bridge the Z21 events and commands to an MQTT broker",
+	Long: `Publish the broadcasts of the Z21 to an MQTT broker and run the commands
+received on the command topics. The topics start with --topic, by default
+z21/<context>:
+
+  status                    online or offline
+  system                    system state (JSON)
+  track/power               on or off
+  track/status              track status (JSON)
+  track/short_circuit       published on a short circuit
+  can/<netid>/<port>        CAN occupancy, e.g. can/0xDB04/3 (JSON)
+  rbus/<module>/<input>     R-Bus feedback (JSON)
+  railcom/<addr>            RailCom data (JSON)
+  loco/<addr>               loco speed, direction and functions (JSON)
+  clock                     fast clock (JSON)
+  loconet                   LocoNet messages (JSON)
+
+Command topics:
+
+  power/set                 on or off
+  loco/<addr>/set           {"speed": 40, "forward": true} or {"stop": true}
+  accessory/<addr>/set      straight or diverging
+
+The bridge subscribes to the broadcasts given with --sub. Loco info is
+published for the locos given with --loco and the locos driven by the
+bridge.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		broker, _ := cmd.Flags().GetString("broker")
+		prefix, _ := cmd.Flags().GetString("topic")
+		clientID, _ := cmd.Flags().GetString("client-id")
+		username, _ := cmd.Flags().GetString("username")
+		password, _ := cmd.Flags().GetString("password")
+		qos, _ := cmd.Flags().GetUint8("qos")
+		retain, _ := cmd.Flags().GetBool("retain")
+		names, _ := cmd.Flags().GetStringSlice("sub")
+		locos, _ := cmd.Flags().GetStringSlice("loco")
+
+		if qos > 2 {
+			return fmt.Errorf("invalid QoS %d (0-2)", qos)
+		}
+
+		var flags uint32
+		for _, name := range names {
+			f, err := getSub(name)
+			if err != nil {
+				return err
+			}
+			flags |= f
+		}
+
+		addrs := []uint16{}
+		for _, l := range locos {
+			addr, err := parseLocoAddress(l)
+			if err != nil {
+				return err
+			}
+			addrs = append(addrs, addr)
+		}
+
+		app := GetAppContext(cmd)
+		if app == nil || app.Conn == nil {
+			return fmt.Errorf("Z21 connection not initialized")
+		}
+
+		if prefix == "" {
+			prefix = "z21/" + app.ContextName
+		}
+		b := &mqttBridge{
+			app:    app,
+			prefix: strings.TrimSuffix(prefix, "/"),
+			qos:    qos,
+			retain: retain,
+		}
+
+		if err := subscribe(app.Conn, flags); err != nil {
+			return err
+		}
+		for _, addr := range addrs {
+			if _, err := Req(app.Conn, &locoInfoReq{Address: addr}); err != nil {
+				return err
+			}
+		}
+
+		opts := mqtt.NewClientOptions().
+			AddBroker(broker).
+			SetClientID(clientID).
+			SetUsername(username).
+			SetPassword(password).
+			SetWill(b.topic("status"), MQTT_STATUS_OFFLINE, qos, true).
+			SetOnConnectHandler(b.onConnect).
+			SetConnectionLostHandler(func(c mqtt.Client, err error) {
+				fmt.Printf("[MQT] connection lost: %s\n", err)
+			})
+		b.client = mqtt.NewClient(opts)
+
+		t := b.client.Connect()
+		if !t.WaitTimeout(MQTT_CONNECT_TIMEOUT) {
+			return fmt.Errorf("timeout connecting to %s", broker)
+		}
+		if err := t.Error(); err != nil {
+			return fmt.Errorf("connecting to %s: %w", broker, err)
+		}
+		defer b.client.Disconnect(250)
+
+		fmt.Printf("Bridging Z21 %q to %s as %s/...\n", app.ContextName, broker, b.prefix)
+		b.pump()
+		return nil
+	},
+}
+
+// mqttBridge serializes the commands of the command topics, as the replies
+// of the Z21 are read from the shared connection.
+type mqttBridge struct {
+	app    *AppContext
+	client mqtt.Client
+	prefix string
+	qos    byte
+	retain bool
+	mu     sync.Mutex // held while a command runs or a broadcast is read
+}
+
+// mqttMessage is a message published by the bridge. Only state messages are
+// retained, so that new subscribers do not receive old events.
+type mqttMessage struct {
+	topic   string
+	payload []byte
+	state   bool
+}
+
+func (b *mqttBridge) topic(parts ...string) string {
+	return b.prefix + "/" + strings.Join(parts, "/")
+}
+
+// onConnect subscribes to the command topics, the session is not kept by
+// the broker on reconnects.
+func (b *mqttBridge) onConnect(c mqtt.Client) {
+	c.Publish(b.topic("status"), b.qos, true, MQTT_STATUS_ONLINE)
+
+	for topic, h := range map[string]mqtt.MessageHandler{
+		b.topic("power", "set"):          b.setPower,
+		b.topic("loco", "+", "set"):      b.setLoco,
+		b.topic("accessory", "+", "set"): b.setAccessory,
+	} {
+		t := c.Subscribe(topic, b.qos, h)
+		if t.Wait() && t.Error() != nil {
+			fmt.Printf("[MQT] subscribe %s: %s\n", topic, t.Error())
+		}
+	}
+}
+
+// pump publishes the broadcasts.
+func (b *mqttBridge) pump() {
+	rbus := map[uint8]*rbusData{}
+	readBroadcasts(b.app, &b.mu, nil,
+		func(ev z21.Serializable) {
+			b.publish(newStreamEvents(b.app, ev))
+		},
+		func(f *z21.Frame) {
+			b.publish(frameStreamEvents(f, rbus))
+		},
+	)
+}
+
+func (b *mqttBridge) publish(events []streamEvent) {
+	for _, ev := range events {
+		for _, m := range b.messages(&ev) {
+			b.client.Publish(m.topic, b.qos, b.retain && m.state, m.payload)
+		}
+	}
+}
+
+// messages maps an event to its topics.
+func (b *mqttBridge) messages(ev *streamEvent) []mqttMessage {
+	data, err := json.Marshal(ev.Data)
+	if err != nil {
+		return nil
+	}
+
+	switch v := ev.Data.(type) {
+	case map[string]bool:
+		if on, ok := v["power"]; ok {
+			power := "off"
+			if on {
+				power = "on"
+			}
+			return []mqttMessage{{b.topic("track", "power"), []byte(power), true}}
+		}
+		return []mqttMessage{{b.topic("track", "short_circuit"), []byte("true"), false}}
+	case trackStatusReport:
+		return []mqttMessage{{b.topic("track", "status"), data, true}}
+	case canPortEventReport:
+		return []mqttMessage{{b.topic("can", fmt.Sprintf("0x%04X", v.NetworkID), fmt.Sprint(v.Port)), data, true}}
+	case rbusEventReport:
+		return []mqttMessage{{b.topic("rbus", fmt.Sprint(v.Module), fmt.Sprint(v.Input)), data, true}}
+	case railcomEventReport:
+		return []mqttMessage{{b.topic("railcom", fmt.Sprint(v.Address)), data, true}}
+	case locoEventReport:
+		return []mqttMessage{{b.topic("loco", fmt.Sprint(v.Address)), data, true}}
+	}
+
+	switch ev.Type {
+	case EVENT_TYPE_SYSTEM, EVENT_TYPE_CLOCK:
+		return []mqttMessage{{b.topic(ev.Type), data, true}}
+	case EVENT_TYPE_LOCONET:
+		return []mqttMessage{{b.topic(ev.Type), data, false}}
+	}
+	return nil
+}
+
+// ---------- handlers ----------
+
+// run runs a command with the connection locked and logs its result.
+func (b *mqttBridge) run(m mqtt.Message, f func() error) {
+	b.mu.Lock()
+	err := f()
+	b.mu.Unlock()
+
+	if err != nil {
+		fmt.Printf("[MQT] %s %s: %s\n", m.Topic(), m.Payload(), err)
+		return
+	}
+	fmt.Printf("[MQT] %s %s\n", m.Topic(), m.Payload())
+}
+
+func (b *mqttBridge) setPower(c mqtt.Client, m mqtt.Message) {
+	b.run(m, func() error {
+		var on bool
+		switch strings.ToLower(strings.TrimSpace(string(m.Payload()))) {
+		case "on":
+			on = true
+		case "off":
+			on = false
+		default:
+			return fmt.Errorf("expected on or off")
+		}
+		if err := setTrackPower(b.app, on); err != nil {
+			return err
+		}
+		// the reply of the Z21 is not seen by the pump
+		b.publish([]streamEvent{{EVENT_TYPE_TRACK, time.Now(), map[string]bool{"power": on}}})
+		return nil
+	})
+}
+
+func (b *mqttBridge) setLoco(c mqtt.Client, m mqtt.Message) {
+	b.run(m, func() error {
+		addr, err := parseLocoAddress(topicAddress(m.Topic()))
+		if err != nil {
+			return err
+		}
+		var req locoRequest
+		if err := json.Unmarshal(m.Payload(), &req); err != nil {
+			return err
+		}
+		if _, err := req.apply(b.app, addr); err != nil {
+			return err
+		}
+		// the Z21 broadcasts the state of the locos asked for
+		_, err = Req(b.app.Conn, &locoInfoReq{Address: addr})
+		return err
+	})
+}
+
+func (b *mqttBridge) setAccessory(c mqtt.Client, m mqtt.Message) {
+	b.run(m, func() error {
+		addr, err := parseTurnoutAddress(topicAddress(m.Topic()))
+		if err != nil {
+			return err
+		}
+		pos, err := parseTurnoutPosition(strings.TrimSpace(string(m.Payload())))
+		if err != nil {
+			return err
+		}
+		return setTurnout(b.app, addr, pos)
+	})
+}
+
+// topicAddress returns the address of a command topic, e.g. 3 of
+// z21/home/loco/3/set.
+func topicAddress(topic string) string {
+	parts := strings.Split(topic, "/")
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[len(parts)-2]
+}
+
+// ---------- init ----------
+
+func init() {
+	bridgeMqttCmd.Flags().String("broker", DEFAULT_MQTT_BROKER, "URL of the MQTT broker")
+	bridgeMqttCmd.Flags().String("topic", "", "topic prefix (default z21/<context>)")
+	bridgeMqttCmd.Flags().String("client-id", DEFAULT_MQTT_CLIENT_ID, "MQTT client ID")
+	bridgeMqttCmd.Flags().String("username", "", "MQTT user name")
+	bridgeMqttCmd.Flags().String("password", "", "MQTT password")
+	bridgeMqttCmd.Flags().Uint8("qos", 0, "QoS of the published and subscribed messages (0-2)")
+	bridgeMqttCmd.Flags().Bool("retain", true, "retain the state messages")
+	bridgeMqttCmd.Flags().StringSlice("sub", []string{"TRACK_UPDATES", "SYSTEM_UPDATES", "CAN_DETECTOR_UPDATES", "FEEDBACK_UPDATES"},
+		"broadcasts to subscribe")
+	bridgeMqttCmd.Flags().StringSlice("loco", nil, "addresses of the locos to publish")
+
+	bridgeCmd.AddCommand(
+		bridgeMqttCmd,
+	)
+}
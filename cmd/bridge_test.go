@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/trains-io/z21.go"
+)
+
+func TestMqttBridgeMessages(t *testing.T) {
+	b := &mqttBridge{prefix: "z21/home"}
+	now := time.Now()
+
+	tests := []struct {
+		name  string
+		ev    streamEvent
+		topic string
+		data  string
+		state bool
+	}{
+		{
+			name:  "power on",
+			ev:    streamEvent{EVENT_TYPE_TRACK, now, map[string]bool{"power": true}},
+			topic: "z21/home/track/power",
+			data:  "on",
+			state: true,
+		},
+		{
+			name:  "power off",
+			ev:    streamEvent{EVENT_TYPE_TRACK, now, map[string]bool{"power": false}},
+			topic: "z21/home/track/power",
+			data:  "off",
+			state: true,
+		},
+		{
+			name:  "short circuit",
+			ev:    streamEvent{EVENT_TYPE_TRACK, now, map[string]bool{"short_circuit": true}},
+			topic: "z21/home/track/short_circuit",
+			data:  "true",
+		},
+		{
+			name:  "track status",
+			ev:    streamEvent{EVENT_TYPE_TRACK, now, trackStatusReport{ShortCircuit: true}},
+			topic: "z21/home/track/status",
+			data:  `{"emergency_stop":false,"track_voltage_off":false,"short_circuit":true,"programming_mode":false}`,
+			state: true,
+		},
+		{
+			name:  "system",
+			ev:    streamEvent{EVENT_TYPE_SYSTEM, now, map[string]int{"temperature": 30}},
+			topic: "z21/home/system",
+			data:  `{"temperature":30}`,
+			state: true,
+		},
+		{
+			name:  "CAN port",
+			ev:    streamEvent{EVENT_TYPE_CAN, now, canPortEventReport{NetworkID: 0xdb04, Port: 3, Block: "Yard", Status: "busy", Busy: true}},
+			topic: "z21/home/can/0xDB04/3",
+			data:  `{"netid":56068,"port":3,"block":"Yard","status":"busy","busy":true}`,
+			state: true,
+		},
+		{
+			name:  "R-Bus input",
+			ev:    streamEvent{EVENT_TYPE_RBUS, now, rbusEventReport{Module: 2, Input: 5, Busy: true}},
+			topic: "z21/home/rbus/2/5",
+			data:  `{"module":2,"input":5,"busy":true}`,
+			state: true,
+		},
+		{
+			name:  "RailCom",
+			ev:    streamEvent{EVENT_TYPE_RAILCOM, now, railcomEventReport{Address: 3, Speed: "40", QoS: "100%"}},
+			topic: "z21/home/railcom/3",
+			data:  `{"address":3,"receive_counter":0,"error_counter":0,"speed":"40","qos":"100%"}`,
+			state: true,
+		},
+		{
+			name:  "loco",
+			ev:    streamEvent{EVENT_TYPE_LOCO, now, locoEventReport{Address: 1000, Speed: 40, SpeedSteps: 128, Forward: true, Functions: []int{0}}},
+			topic: "z21/home/loco/1000",
+			data:  `{"address":1000,"speed":40,"speed_steps":128,"forward":true,"emergency_stop":false,"functions":[0]}`,
+			state: true,
+		},
+		{
+			name:  "clock",
+			ev:    streamEvent{EVENT_TYPE_CLOCK, now, map[string]any{"time": "12:00"}},
+			topic: "z21/home/clock",
+			data:  `{"time":"12:00"}`,
+			state: true,
+		},
+		{
+			name:  "LocoNet",
+			ev:    streamEvent{EVENT_TYPE_LOCONET, now, map[string]string{"message": "OPC_GPON"}},
+			topic: "z21/home/loconet",
+			data:  `{"message":"OPC_GPON"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		msgs := b.messages(&tt.ev)
+		if len(msgs) != 1 {
+			t.Errorf("%s: %d messages, want 1", tt.name, len(msgs))
+			continue
+		}
+		m := msgs[0]
+		if m.topic != tt.topic || string(m.payload) != tt.data || m.state != tt.state {
+			t.Errorf("%s: message %s %s (state %t), want %s %s (state %t)",
+				tt.name, m.topic, m.payload, m.state, tt.topic, tt.data, tt.state)
+		}
+	}
+}
+
+func TestMqttBridgeMessagesUnknown(t *testing.T) {
+	b := &mqttBridge{prefix: "z21/home"}
+	ev := streamEvent{"unknown", time.Now(), &z21.SerialNumber{}}
+	if msgs := b.messages(&ev); len(msgs) != 0 {
+		t.Errorf("%d messages for an unknown event, want none", len(msgs))
+	}
+}
+
+func TestTopicAddress(t *testing.T) {
+	tests := []struct {
+		topic string
+		want  string
+	}{
+		{"z21/home/loco/3/set", "3"},
+		{"z21/home/accessory/12/set", "12"},
+		{"layout/z21/loco/1000/set", "1000"},
+		{"3/set", "3"},
+		{"set", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := topicAddress(tt.topic); got != tt.want {
+			t.Errorf("topicAddress(%q) = %q, want %q", tt.topic, got, tt.want)
+		}
+	}
+}
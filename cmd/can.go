@@ -1,20 +1,36 @@
 package cmd
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"fmt"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
 	"github.com/spf13/cobra"
 	"github.com/trains-io/z21.go"
 )
 
 const (
 	DEFAULT_SCAN_TIMEOUT time.Duration = 2 * time.Second
+	CAN_DESCRIPTION_LEN  int           = 16
+	DEFAULT_HIGHLIGHT    time.Duration = 3 * time.Second
+
+	DEFAULT_DISCOVER_SETTLE time.Duration = 100 * time.Millisecond
+)
+
+const (
+	CAN_MESSAGE_TYPE_RAILCOM_FIRST uint8 = 0x11
+	CAN_MESSAGE_TYPE_RAILCOM_LAST  uint8 = 0x1f
+
+	CAN_LOCO_FORWARD uint8 = 0x02
+	CAN_LOCO_REVERSE uint8 = 0x03
 )
 
 var canCmd = &cobra.Command{
@@ -24,58 +40,128 @@ var canCmd = &cobra.Command{
 
 // ---------- subcommands ----------
 
-// discover [--timeout | -t SECONDS]
+// discover [--timeout | -t SECONDS] [--sort netid|addr] [--expect N] [--quiet-period DURATION]
 var canDiscoverCmd = &cobra.Command{
 	Use:     "discover",
 	Aliases: []string{"d"},
 	Short:   "Discover and list all CAN devices",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		timeout, _ := cmd.Flags().GetDuration("timeout")
+		sortBy, _ := cmd.Flags().GetString("sort")
+		expect, _ := cmd.Flags().GetInt("expect")
+		quiet, _ := cmd.Flags().GetDuration("quiet-period")
+
+		if sortBy != "netid" && sortBy != "addr" {
+			return fmt.Errorf("invalid sort %q, expected netid or addr", sortBy)
+		}
 
 		app := GetAppContext(cmd)
 		if app == nil || app.Conn == nil {
 			return fmt.Errorf("Z21 connection not initialized")
 		}
-		events := app.Conn.Events()
 
-		_, err := Req(app.Conn, &z21.CanDetector{NetworkID: z21.CAN_BROADCAST_NID})
+		fmt.Printf("Discover CAN devices (timeout: %s) ...\n", timeout)
+		var complete func(map[uint16]*canDevice) bool
+		if expect > 0 {
+			complete = func(devices map[uint16]*canDevice) bool { return len(devices) >= expect }
+		}
+		devices, err := discoverCanDevices(app, timeout, quiet, complete, nil)
 		if err != nil {
 			return err
 		}
+		printCanDevices(devices, sortBy)
+		return nil
+	},
+}
+
+// discoverCanDevices asks all CAN detectors for their state and collects the
+// replies. It returns after timeout, once no reply arrived for the quiet
+// period, or shortly after complete reports the expected devices answered. A
+// zero quiet period or a nil complete disables the early exit. The events
+// received before the request and the events other than CAN detector replies
+// are passed to other, or dropped if other is nil.
+func discoverCanDevices(app *AppContext, timeout, quiet time.Duration, complete func(map[uint16]*canDevice) bool, other func(z21.Serializable)) (map[uint16]*canDevice, error) {
+	events := app.Conn.Events()
+	if other == nil {
+		other = func(z21.Serializable) {}
+	}
+
+	// stale CAN detector events are not replies to this request
+drain:
+	for {
+		select {
+		case ev := <-events:
+			other(ev)
+		default:
+			break drain
+		}
+	}
 
-		ctx, cancel := context.WithTimeout(context.Background(), timeout)
-		defer cancel()
+	_, err := Req(app.Conn, &z21.CanDetector{NetworkID: z21.CAN_BROADCAST_NID})
+	if err != nil {
+		return nil, err
+	}
 
-		devices := map[uint16]z21.Detector{}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
-		fmt.Printf("Discover CAN devices (timeout: %s) ...\n", timeout)
-		for {
-			select {
-			case <-ctx.Done():
-				printCanDevices(devices)
-				return nil
-			case ev := <-events:
-				switch v := ev.(type) {
-				case *z21.CanDetector:
-					if v.Type == z21.CANMessageTypeStatus {
-						dev, exists := devices[v.NetworkID]
-						if !exists {
-							dev = z21.Detector{
-								NetworkID: v.NetworkID,
-								Address:   v.Address,
-								Ports:     []z21.DetectorPort{},
-							}
-						}
-						dev.Ports = append(dev.Ports, z21.DetectorPort{Index: v.Port})
-						devices[v.NetworkID] = dev
-					}
+	devices := map[uint16]*canDevice{}
+
+	// a device replies with one message per port, wait for the remaining
+	// ports of the last expected device before returning
+	idleTimeout := func() time.Duration {
+		if complete != nil && complete(devices) {
+			return DEFAULT_DISCOVER_SETTLE
+		}
+		if quiet > 0 {
+			return quiet
+		}
+		return timeout
+	}
+	idle := time.NewTimer(idleTimeout())
+	defer idle.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return devices, nil
+		case <-idle.C:
+			return devices, nil
+		case ev := <-events:
+			switch v := ev.(type) {
+			case *z21.CanDetector:
+				dev, exists := devices[v.NetworkID]
+				if !exists {
+					dev = newCanDevice(v)
+					devices[v.NetworkID] = dev
+					app.CanDevices[v.NetworkID] = true
 				}
+				dev.update(v)
+				idle.Reset(idleTimeout())
+			default:
+				other(ev)
 			}
 		}
-	},
+	}
+}
+
+// sortCanDevices returns the devices ordered by NetID or by address.
+func sortCanDevices(devices map[uint16]*canDevice, sortBy string) []*canDevice {
+	sorted := []*canDevice{}
+	for _, d := range devices {
+		sorted = append(sorted, d)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if sortBy == "addr" && a.Address != b.Address {
+			return a.Address < b.Address
+		}
+		return a.NetworkID < b.NetworkID
+	})
+	return sorted
 }
 
-func printCanDevices(devices map[uint16]z21.Detector) {
+func printCanDevices(devices map[uint16]*canDevice, sortBy string) {
 	if len(devices) == 0 {
 		fmt.Printf("No devices found\n")
 		return
@@ -85,20 +171,21 @@ func printCanDevices(devices map[uint16]z21.Detector) {
 	t.SetOutputMirror(os.Stdout)
 	t.Style().Options.DrawBorder = false
 	t.Style().Options.SeparateColumns = false
-	t.AppendHeader(table.Row{"NetID", "Addr", "Port(s)"})
-	for _, d := range devices {
+	t.AppendHeader(table.Row{"NetID", "Addr", "Port(s)", "Busy"})
+	for _, d := range sortCanDevices(devices, sortBy) {
 		t.AppendRow(
 			table.Row{
 				fmt.Sprintf("0x%04X", d.NetworkID),
 				fmt.Sprintf("%d", d.Address),
 				formatPortsAsRange(d.Ports),
+				formatPortsAsRange(d.busyPorts()),
 			},
 		)
 	}
 	t.Render()
 }
 
-func printCanDeviceInfo(d *z21.Detector) {
+func printCanDeviceInfo(d *canDevice, blocks map[string]string) {
 	if d == nil {
 		fmt.Printf("Device not found\n")
 		return
@@ -109,12 +196,14 @@ func printCanDeviceInfo(d *z21.Detector) {
 	t.SetOutputMirror(os.Stdout)
 	t.Style().Options.DrawBorder = false
 	t.Style().Options.SeparateColumns = false
-	t.AppendHeader(table.Row{"Port", "Status"})
+	t.AppendHeader(table.Row{"Port", "Block", "Status", "Loco(s)"})
 	for _, p := range d.Ports {
 		t.AppendRow(
 			table.Row{
 				formatPortIndex(p.Index),
+				blocks[formatCanPort(d.NetworkID, p.Index)],
 				formatPortStatus(p.Status),
+				formatCanLocos(d.Locos[p.Index]),
 			},
 		)
 	}
@@ -127,11 +216,56 @@ func formatPortIndex(index uint8) string {
 }
 
 func formatPortStatus(status uint16) string {
-	if status == z21.FREE || status == z21.FREE_NOVOLT {
+	switch status {
+	case z21.FREE:
 		return "free"
-	} else {
+	case z21.FREE_NOVOLT:
+		return "free (no voltage)"
+	case z21.BUSY:
 		return "busy"
+	case z21.BUSY_NOVOLT:
+		return "busy (no voltage)"
+	case z21.BUSY_OVERLOAD1:
+		return "busy (overload 1)"
+	case z21.BUSY_OVERLOAD2:
+		return "busy (overload 2)"
+	case z21.BUSY_OVERLOAD3:
+		return "busy (overload 3)"
+	default:
+		return fmt.Sprintf("unknown (0x%04x)", status)
+	}
+}
+
+func formatPortStatusShort(status uint16) string {
+	switch status {
+	case z21.FREE:
+		return "."
+	case z21.FREE_NOVOLT:
+		return "off"
+	case z21.BUSY:
+		return "#"
+	case z21.BUSY_NOVOLT:
+		return "# off"
+	case z21.BUSY_OVERLOAD1, z21.BUSY_OVERLOAD2, z21.BUSY_OVERLOAD3:
+		return "# ovl"
+	default:
+		return "?"
+	}
+}
+
+func isPortBusy(status uint16) bool {
+	return status&0x1000 != 0
+}
+
+func formatCanLocos(locos []canLoco) string {
+	out := []string{}
+	for _, l := range locos {
+		if l.Address == 0 {
+			continue
+		}
+		out = append(out, l.String())
 	}
+	return strings.Join(out, ",")
 }
 
 func formatPortsAsRange(ports []z21.DetectorPort) string {
@@ -168,11 +302,12 @@ func formatRange(start, previous uint8) string {
 	return fmt.Sprintf("%d-%d", start, previous)
 }
 
-// info NETID
+// info NETID [--json]
 var canInfoCmd = &cobra.Command{
-	Use:     "info",
+	Use:     "info NETID",
 	Aliases: []string{"i"},
 	Short:   "Show CAN device information",
+	Args:    cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		hexstr := args[0]
 		val, err := strconv.ParseUint(hexstr, 0, 16)
@@ -186,50 +321,450 @@ var canInfoCmd = &cobra.Command{
 		if app == nil || app.Conn == nil {
 			return fmt.Errorf("Z21 connection not initialized")
 		}
-		events := app.Conn.Events()
 
-		_, err = Req(app.Conn, &z21.CanDetector{NetworkID: netid})
+		device, err := getCanDevice(app, netid, timeout)
+		if err != nil {
+			return err
+		}
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			if device == nil {
+				return fmt.Errorf("device 0x%04X not found", netid)
+			}
+			return printJSON(device.report(app.CanBlocks))
+		}
+		printCanDeviceInfo(device, app.CanBlocks)
+		return nil
+	},
+}
+
+func getCanDevice(app *AppContext, netid uint16, timeout time.Duration) (*canDevice, error) {
+	events := app.Conn.Events()
+
+	_, err := Req(app.Conn, &z21.CanDetector{NetworkID: netid})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var device *canDevice
+
+	for {
+		select {
+		case <-ctx.Done():
+			return device, nil
+		case ev := <-events:
+			switch v := ev.(type) {
+			case *z21.CanDetector:
+				if v.NetworkID != netid {
+					continue
+				}
+				if device == nil {
+					device = newCanDevice(v)
+				}
+				device.update(v)
+			}
+		}
+	}
+}
+
+// name NETID NAME
+var canNameCmd = &cobra.Command{
+	Use:   "name NETID NAME",
+	Short: "Set the name of a CAN device",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		val, err := strconv.ParseUint(args[0], 0, 16)
+		if err != nil {
+			return err
+		}
+		netid := uint16(val)
+		name := args[1]
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+
+		if len(name) > CAN_DESCRIPTION_LEN {
+			return fmt.Errorf("name %q exceeds %d characters", name, CAN_DESCRIPTION_LEN)
+		}
+
+		app := GetAppContext(cmd)
+		if app == nil || app.Conn == nil {
+			return fmt.Errorf("Z21 connection not initialized")
+		}
+
+		_, err = Req(app.Conn, &canSetDescription{NetworkID: netid, Name: name})
+		if err != nil {
+			return err
+		}
+
+		// re-read the device to confirm the change
+		desc, err := getCanDescription(app, netid)
+		if err != nil {
+			return err
+		}
+		if desc != name {
+			return fmt.Errorf("failed to name CAN device 0x%04X", netid)
+		}
+
+		device, err := getCanDevice(app, netid, timeout)
 		if err != nil {
 			return err
 		}
+		fmt.Printf("Name: %s\n", desc)
+		printCanDeviceInfo(device, app.CanBlocks)
+		return nil
+	},
+}
+
+func getCanDescription(app *AppContext, netid uint16) (string, error) {
+	f, err := ReqFrame(app, &canGetDescription{NetworkID: netid}, DEFAULT_REQ_TIMEOUT, func(f *z21.Frame) bool {
+		return f.Header == z21.LAN_CAN_DEVICE_GET_DESCRIPTION &&
+			len(f.Payload) >= 2 &&
+			binary.LittleEndian.Uint16(f.Payload) == netid
+	})
+	if err != nil {
+		return "", err
+	}
+
+	d := &canGetDescription{}
+	if err := d.Unpack(f.Payload); err != nil {
+		return "", err
+	}
+	return d.Name, nil
+}
+
+// watch [--timeout | -t SECONDS] [--highlight DURATION]
+var canWatchCmd = &cobra.Command{
+	Use:     "watch",
+	Aliases: []string{"w"},
+	Short:   "Show a live occupancy grid of all CAN detectors",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		highlight, _ := cmd.Flags().GetDuration("highlight")
+		if highlight <= 0 {
+			return fmt.Errorf("invalid highlight duration %s", highlight)
+		}
+
+		app := GetAppContext(cmd)
+		if app == nil || app.Conn == nil {
+			return fmt.Errorf("Z21 connection not initialized")
+		}
+
+		if err := subscribe(app.Conn, z21.CAN_DETECTOR_UPDATES); err != nil {
+			return err
+		}
+		events := app.Conn.Events()
 
-		ctx, cancel := context.WithTimeout(context.Background(), timeout)
-		defer cancel()
+		fmt.Printf("Discover CAN devices (timeout: %s) ...\n", timeout)
+		devices, err := discoverCanDevices(app, timeout, 0, nil, nil)
+		if err != nil {
+			return err
+		}
+		changed := map[canPortKey]time.Time{}
 
-		var device *z21.Detector
+		ticker := time.NewTicker(highlight / 4)
+		defer ticker.Stop()
 
+		printCanWatch(devices, changed, app.CanBlocks)
 		for {
 			select {
-			case <-ctx.Done():
-				printCanDeviceInfo(device)
-				return nil
-			case ev := <-events:
-				switch v := ev.(type) {
-				case *z21.CanDetector:
-					if v.Type == z21.CANMessageTypeStatus {
-						if device == nil {
-							device = &z21.Detector{
-								NetworkID: v.NetworkID,
-								Address:   v.Address,
-								Ports:     []z21.DetectorPort{},
-							}
-						}
-						device.Ports = append(device.Ports, z21.DetectorPort{Index: v.Port, Status: v.Value1})
+			case <-ticker.C:
+				// redraw once a highlight has expired
+				expired := false
+				for k, t := range changed {
+					if time.Since(t) >= highlight {
+						delete(changed, k)
+						expired = true
 					}
 				}
+				if expired {
+					printCanWatch(devices, changed, app.CanBlocks)
+				}
+			case ev := <-events:
+				v, ok := ev.(*z21.CanDetector)
+				if !ok {
+					continue
+				}
+				dev, exists := devices[v.NetworkID]
+				if !exists {
+					dev = newCanDevice(v)
+					devices[v.NetworkID] = dev
+				}
+				before := dev.formatPort(v.Port)
+				dev.update(v)
+				if dev.formatPort(v.Port) != before {
+					changed[canPortKey{v.NetworkID, v.Port}] = time.Now()
+				}
+				printCanWatch(devices, changed, app.CanBlocks)
 			}
 		}
 	},
 }
 
-// set NETID --addr <ADDR>
-var canSetCmd = &cobra.Command{
-	Use:     "set",
-	Aliases: []string{"s"},
-	Short:   "Configure CAN device",
-	RunE: func(cmd *cobra.Command, args []string) error {
-		return nil
-	},
+type canPortKey struct {
+	NetworkID uint16
+	Port      uint8
+}
+
+func printCanWatch(devices map[uint16]*canDevice, changed map[canPortKey]time.Time, blocks map[string]string) {
+	// clear screen and move the cursor home
+	fmt.Print("\033[H\033[2J")
+
+	if len(devices) == 0 {
+		fmt.Printf("No devices found\n")
+		return
+	}
+
+	ports := 0
+	for _, d := range devices {
+		if len(d.Ports) > 0 {
+			ports = max(ports, int(d.Ports[len(d.Ports)-1].Index)+1)
+		}
+	}
+
+	header := table.Row{"NetID", "Addr"}
+	for i := 0; i < ports; i++ {
+		header = append(header, formatPortIndex(uint8(i)))
+	}
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.Style().Options.DrawBorder = false
+	t.Style().Options.SeparateColumns = false
+	t.AppendHeader(header)
+	for _, d := range sortCanDevices(devices, "netid") {
+		row := table.Row{
+			fmt.Sprintf("0x%04X", d.NetworkID),
+			fmt.Sprintf("%d", d.Address),
+		}
+		for i := 0; i < ports; i++ {
+			cell := d.formatPort(uint8(i))
+			if name := blocks[formatCanPort(d.NetworkID, uint8(i))]; name != "" {
+				cell = name + "\n" + cell
+			}
+			if _, ok := changed[canPortKey{d.NetworkID, uint8(i)}]; ok {
+				cell = text.Colors{text.ReverseVideo}.Sprint(cell)
+			}
+			row = append(row, cell)
+		}
+		t.AppendRow(row)
+	}
+	t.Render()
+
+	fmt.Println()
+	fmt.Printf("Updated: %s (Ctrl-C to quit)\n", time.Now().Format("15:04:05"))
+}
+
+// ---------- devices ----------
+
+// canDevice is a CAN occupancy detector with the state of its ports and the
+// RailCom loco addresses reported per port.
+type canDevice struct {
+	z21.Detector
+	Locos map[uint8][]canLoco
+}
+
+type canLoco struct {
+	Address   uint16
+	Direction uint8
+}
+
+func newCanDevice(v *z21.CanDetector) *canDevice {
+	return &canDevice{
+		Detector: z21.Detector{
+			NetworkID: v.NetworkID,
+			Address:   v.Address,
+			Ports:     []z21.DetectorPort{},
+		},
+		Locos: map[uint8][]canLoco{},
+	}
+}
+
+// update applies a LAN_CAN_DETECTOR message to the device. Type 0x01 carries
+// the occupancy status in Value1, types 0x11 to 0x1f carry two RailCom loco
+// addresses each in Value1 and Value2.
+func (d *canDevice) update(v *z21.CanDetector) {
+	switch {
+	case v.Type == z21.CANMessageTypeStatus:
+		d.setPortStatus(v.Port, v.Value1)
+		if !isPortBusy(v.Value1) {
+			delete(d.Locos, v.Port)
+		}
+	case v.Type >= CAN_MESSAGE_TYPE_RAILCOM_FIRST && v.Type <= CAN_MESSAGE_TYPE_RAILCOM_LAST:
+		i := int(v.Type-CAN_MESSAGE_TYPE_RAILCOM_FIRST) * 2
+		locos := d.Locos[v.Port]
+		for len(locos) < i+2 {
+			locos = append(locos, canLoco{})
+		}
+		locos[i] = newCanLoco(v.Value1)
+		locos[i+1] = newCanLoco(v.Value2)
+		d.Locos[v.Port] = locos
+	}
+}
+
+func (d *canDevice) setPortStatus(index uint8, status uint16) {
+	for i := range d.Ports {
+		if d.Ports[i].Index == index {
+			d.Ports[i].Status = status
+			return
+		}
+	}
+	d.Ports = append(d.Ports, z21.DetectorPort{Index: index, Status: status})
+	sort.Slice(d.Ports, func(i, j int) bool {
+		return d.Ports[i].Index < d.Ports[j].Index
+	})
+}
+
+// formatPort returns the short status of a port followed by the locos in the
+// section, as shown in the watch grid.
+func (d *canDevice) formatPort(index uint8) string {
+	for _, p := range d.Ports {
+		if p.Index != index {
+			continue
+		}
+		status := formatPortStatusShort(p.Status)
+		if locos := formatCanLocos(d.Locos[index]); locos != "" {
+			status += " " + locos
+		}
+		return status
+	}
+	return ""
+}
+
+func (d *canDevice) busyPorts() []z21.DetectorPort {
+	busy := []z21.DetectorPort{}
+	for _, p := range d.Ports {
+		if isPortBusy(p.Status) {
+			busy = append(busy, p)
+		}
+	}
+	return busy
+}
+
+type canDeviceReport struct {
+	NetworkID uint16          `json:"netid"`
+	Address   uint16          `json:"address"`
+	Ports     []canPortReport `json:"ports"`
+}
+
+type canPortReport struct {
+	Port   int             `json:"port"`
+	Block  string          `json:"block,omitempty"`
+	Status string          `json:"status"`
+	Busy   bool            `json:"busy"`
+	Locos  []canLocoReport `json:"locos,omitempty"`
+}
+
+type canLocoReport struct {
+	Address   uint16 `json:"address"`
+	Direction string `json:"direction,omitempty"`
+}
+
+// report returns the device state for JSON output.
+func (d *canDevice) report(blocks map[string]string) *canDeviceReport {
+	r := &canDeviceReport{
+		NetworkID: d.NetworkID,
+		Address:   d.Address,
+		Ports:     []canPortReport{},
+	}
+	for _, p := range d.Ports {
+		pr := canPortReport{
+			Port:   int(p.Index) + 1,
+			Block:  blocks[formatCanPort(d.NetworkID, p.Index)],
+			Status: formatPortStatus(p.Status),
+			Busy:   isPortBusy(p.Status),
+		}
+		for _, l := range d.Locos[p.Index] {
+			if l.Address == 0 {
+				continue
+			}
+			pr.Locos = append(pr.Locos, canLocoReport{Address: l.Address, Direction: l.direction()})
+		}
+		r.Ports = append(r.Ports, pr)
+	}
+	return r
+}
+
+// newCanLoco decodes a RailCom value: bits 0-13 hold the loco address, bits
+// 14-15 the direction.
+func newCanLoco(v uint16) canLoco {
+	return canLoco{Address: v & 0x3fff, Direction: uint8(v >> 14)}
+}
+
+func (l canLoco) direction() string {
+	switch l.Direction {
+	case CAN_LOCO_FORWARD:
+		return "forward"
+	case CAN_LOCO_REVERSE:
+		return "reverse"
+	default:
+		return ""
+	}
+}
+
+func (l canLoco) String() string {
+	switch l.Direction {
+	case CAN_LOCO_FORWARD:
+		return fmt.Sprintf("%d>", l.Address)
+	case CAN_LOCO_REVERSE:
+		return fmt.Sprintf("<%d", l.Address)
+	default:
+		return fmt.Sprintf("%d", l.Address)
+	}
+}
+
+// ---------- messages ----------
+
+// LAN_CAN_DEVICE_GET_DESCRIPTION
+type canGetDescription struct {
+	NetworkID uint16
+	Name      string
+}
+
+func (m *canGetDescription) Pack() ([]byte, error) {
+	return z21.PackFields(m.NetworkID)
+}
+
+func (m *canGetDescription) Unpack(data []byte) error {
+	if len(data) < 2 {
+		return fmt.Errorf("truncated CAN device description")
+	}
+	m.NetworkID = binary.LittleEndian.Uint16(data)
+	name, _, _ := bytes.Cut(data[2:], []byte{0})
+	m.Name = string(name)
+	return nil
+}
+
+func (m *canGetDescription) EncapType() uint16 {
+	return z21.LAN_CAN_DEVICE_GET_DESCRIPTION
+}
+
+func (m *canGetDescription) Key() (string, bool) {
+	return "", false
+}
+
+// LAN_CAN_DEVICE_SET_DESCRIPTION
+type canSetDescription struct {
+	NetworkID uint16
+	Name      string
+}
+
+func (m *canSetDescription) Pack() ([]byte, error) {
+	name := make([]byte, CAN_DESCRIPTION_LEN)
+	copy(name, m.Name)
+	return z21.PackFields(m.NetworkID, name)
+}
+
+func (m *canSetDescription) Unpack(data []byte) error {
+	return nil
+}
+
+func (m *canSetDescription) EncapType() uint16 {
+	return z21.LAN_CAN_DEVICE_SET_DESCRIPTION
+}
+
+func (m *canSetDescription) Key() (string, bool) {
+	return "", false
 }
 
 // ---------- init ----------
@@ -238,9 +773,17 @@ func init() {
 	canCmd.AddCommand(
 		canDiscoverCmd,
 		canInfoCmd,
-		canSetCmd,
+		canNameCmd,
+		canWatchCmd,
 	)
 
 	canDiscoverCmd.Flags().DurationP("timeout", "t", DEFAULT_SCAN_TIMEOUT, "timeout in seconds")
+	canDiscoverCmd.Flags().String("sort", "netid", "sort devices by netid or addr")
+	canDiscoverCmd.Flags().Int("expect", 0, "return as soon as N devices answered")
+	canDiscoverCmd.Flags().Duration("quiet-period", 0, "return once no reply arrived for this period")
 	canInfoCmd.Flags().DurationP("timeout", "t", DEFAULT_SCAN_TIMEOUT, "timeout in seconds")
+	canInfoCmd.Flags().Bool("json", false, "print the device state as JSON")
+	canNameCmd.Flags().DurationP("timeout", "t", DEFAULT_SCAN_TIMEOUT, "timeout in seconds")
+	canWatchCmd.Flags().DurationP("timeout", "t", DEFAULT_SCAN_TIMEOUT, "discovery timeout in seconds")
+	canWatchCmd.Flags().Duration("highlight", DEFAULT_HIGHLIGHT, "how long changed sections are highlighted")
 }
@@ -0,0 +1,319 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trains-io/z21.go"
+)
+
+const (
+	FAST_CLOCK_READ  uint8 = 0x2A
+	FAST_CLOCK_SET   uint8 = 0x2B
+	FAST_CLOCK_START uint8 = 0x2C
+	FAST_CLOCK_STOP  uint8 = 0x2D
+	FAST_CLOCK_DATA  uint8 = 0x25
+
+	FAST_CLOCK_STOPPED  uint8 = 0x80 // DB4 bit 7
+	FAST_CLOCK_MAX_RATE uint8 = 63
+)
+
+var weekdays = []string{"Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"}
+
+var clockCmd = &cobra.Command{
+	Use:   "clock",
+	Short: "Control the Z21 fast clock",
+}
+
+// ---------- subcommands ----------
+
+// get
+var clockGetCmd = &cobra.Command{
+	Use:   "get",
+	Short: "Show the fast clock time",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		app := GetAppContext(cmd)
+		if app == nil || app.Conn == nil {
+			return fmt.Errorf("Z21 connection not initialized")
+		}
+
+		c, err := getFastClock(app)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(formatFastClock(c))
+		return nil
+	},
+}
+
+// set HH:MM [--day DAY] [--rate N]
+var clockSetCmd = &cobra.Command{
+	Use:   "set HH:MM",
+	Short: "Set the fast clock time, day and rate",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		t, err := time.Parse("15:04", args[0])
+		if err != nil {
+			return fmt.Errorf("invalid time %q, expected HH:MM", args[0])
+		}
+		dayName, _ := cmd.Flags().GetString("day")
+		rate, _ := cmd.Flags().GetUint8("rate")
+
+		app := GetAppContext(cmd)
+		if app == nil || app.Conn == nil {
+			return fmt.Errorf("Z21 connection not initialized")
+		}
+
+		// keep the current day and rate unless given
+		c := &fastClockData{Rate: 1}
+		if !cmd.Flags().Changed("day") || !cmd.Flags().Changed("rate") {
+			if cur, err := getFastClock(app); err == nil {
+				c = cur
+			}
+		}
+		if cmd.Flags().Changed("day") {
+			day, err := parseWeekday(dayName)
+			if err != nil {
+				return err
+			}
+			c.Day = day
+		}
+		if cmd.Flags().Changed("rate") {
+			if rate > FAST_CLOCK_MAX_RATE {
+				return fmt.Errorf("invalid rate %d (0-%d)", rate, FAST_CLOCK_MAX_RATE)
+			}
+			c.Rate = rate
+		}
+		c.Hour = uint8(t.Hour())
+		c.Minute = uint8(t.Minute())
+		c.Second = 0
+
+		_, err = Req(app.Conn, &fastClockControl{
+			Command: FAST_CLOCK_SET,
+			Data:    []byte{c.Day<<5 | c.Hour, c.Minute, c.Rate},
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Fast clock set to %s\n", formatFastClock(c))
+		return nil
+	},
+}
+
+// start
+var clockStartCmd = &cobra.Command{
+	Use:   "start",
+	Short: "Start the fast clock",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		app := GetAppContext(cmd)
+		if app == nil || app.Conn == nil {
+			return fmt.Errorf("Z21 connection not initialized")
+		}
+
+		if _, err := Req(app.Conn, &fastClockControl{Command: FAST_CLOCK_START}); err != nil {
+			return err
+		}
+		fmt.Printf("Fast clock started.\n")
+		return nil
+	},
+}
+
+// stop
+var clockStopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Stop the fast clock",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		app := GetAppContext(cmd)
+		if app == nil || app.Conn == nil {
+			return fmt.Errorf("Z21 connection not initialized")
+		}
+
+		if _, err := Req(app.Conn, &fastClockControl{Command: FAST_CLOCK_STOP}); err != nil {
+			return err
+		}
+		fmt.Printf("Fast clock stopped.\n")
+		return nil
+	},
+}
+
+// watch
+var clockWatchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Show the fast clock in large digits",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		app := GetAppContext(cmd)
+		if app == nil || app.Conn == nil {
+			return fmt.Errorf("Z21 connection not initialized")
+		}
+
+		if err := subscribe(app.Conn, z21.FAST_CLOCK_UPDATES); err != nil {
+			return err
+		}
+
+		if c, err := getFastClock(app); err == nil {
+			printBigFastClock(c)
+		} else {
+			fmt.Println("Waiting for fast clock ...")
+		}
+
+		for f := range app.Frames {
+			if !isFastClockData(&f) {
+				continue
+			}
+			c := &fastClockData{}
+			if err := c.Unpack(f.Payload); err != nil {
+				continue
+			}
+			printBigFastClock(c)
+		}
+		return nil
+	},
+}
+
+func getFastClock(app *AppContext) (*fastClockData, error) {
+	f, err := ReqFrame(app, &fastClockControl{Command: FAST_CLOCK_READ}, DEFAULT_REQ_TIMEOUT, isFastClockData)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &fastClockData{}
+	if err := c.Unpack(f.Payload); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func isFastClockData(f *z21.Frame) bool {
+	return f.Header == z21.LAN_FAST_CLOCK_DATA &&
+		len(f.Payload) >= 2 &&
+		f.Payload[1] == FAST_CLOCK_DATA
+}
+
+func parseWeekday(s string) (uint8, error) {
+	if len(s) >= 3 {
+		for i, d := range weekdays {
+			if strings.EqualFold(d, s[:3]) {
+				return uint8(i), nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("invalid day %q, expected one of %s", s, strings.Join(weekdays, ", "))
+}
+
+func formatWeekday(day uint8) string {
+	if int(day) < len(weekdays) {
+		return weekdays[day]
+	}
+	return "???"
+}
+
+func formatFastClock(c *fastClockData) string {
+	state := "running"
+	if c.Stopped {
+		state = "stopped"
+	}
+	return fmt.Sprintf("%s %02d:%02d:%02d (rate 1:%d, %s)",
+		formatWeekday(c.Day), c.Hour, c.Minute, c.Second, c.Rate, state)
+}
+
+var bigDigits = map[rune][]string{
+	'0': {"###", "# #", "# #", "# #", "###"},
+	'1': {"  #", "  #", "  #", "  #", "  #"},
+	'2': {"###", "  #", "###", "#  ", "###"},
+	'3': {"###", "  #", "###", "  #", "###"},
+	'4': {"# #", "# #", "###", "  #", "  #"},
+	'5': {"###", "#  ", "###", "  #", "###"},
+	'6': {"###", "#  ", "###", "# #", "###"},
+	'7': {"###", "  #", "  #", "  #", "  #"},
+	'8': {"###", "# #", "###", "# #", "###"},
+	'9': {"###", "# #", "###", "  #", "###"},
+	':': {" ", "#", " ", "#", " "},
+}
+
+func printBigFastClock(c *fastClockData) {
+	text := fmt.Sprintf("%02d:%02d", c.Hour, c.Minute)
+
+	// clear screen and move the cursor home
+	fmt.Print("\033[H\033[2J")
+	fmt.Println()
+	for row := 0; row < 5; row++ {
+		line := "  "
+		for _, r := range text {
+			glyph := bigDigits[r][row]
+			glyph = strings.ReplaceAll(glyph, "#", "██")
+			glyph = strings.ReplaceAll(glyph, " ", "  ")
+			line += glyph + "  "
+		}
+		fmt.Println(line)
+	}
+	fmt.Println()
+	fmt.Printf("  %s\n", formatFastClock(c))
+}
+
+// ---------- messages ----------
+
+// LAN_FAST_CLOCK_CONTROL
+type fastClockControl struct {
+	Command uint8
+	Data    []byte
+}
+
+func (m *fastClockControl) Pack() ([]byte, error) {
+	b := []byte{0x21 + uint8(len(m.Data)), m.Command}
+	b = append(b, m.Data...)
+	return append(b, xor(b)), nil
+}
+
+func (m *fastClockControl) Unpack(data []byte) error {
+	return nil
+}
+
+func (m *fastClockControl) EncapType() uint16 {
+	return z21.LAN_FAST_CLOCK_CONTROL
+}
+
+func (m *fastClockControl) Key() (string, bool) {
+	return "", false
+}
+
+// LAN_FAST_CLOCK_DATA
+type fastClockData struct {
+	Day     uint8
+	Hour    uint8
+	Minute  uint8
+	Second  uint8
+	Rate    uint8
+	Stopped bool
+}
+
+func (m *fastClockData) Unpack(data []byte) error {
+	if len(data) < 6 {
+		return fmt.Errorf("truncated fast clock data")
+	}
+	m.Day = data[2] >> 5
+	m.Hour = data[2] & 0x1f
+	m.Minute = data[3] & 0x3f
+	m.Second = data[4] & 0x3f
+	m.Rate = data[5] & 0x3f
+	m.Stopped = data[5]&FAST_CLOCK_STOPPED != 0
+	return nil
+}
+
+// ---------- init ----------
+
+func init() {
+	clockCmd.AddCommand(
+		clockGetCmd,
+		clockSetCmd,
+		clockStartCmd,
+		clockStopCmd,
+		clockWatchCmd,
+	)
+
+	clockSetCmd.Flags().String("day", "Mon", "day of the week (Mon-Sun)")
+	clockSetCmd.Flags().Uint8("rate", 1, "fast clock rate (0-63)")
+}
@@ -12,10 +12,15 @@ import (
 )
 
 type ContextInfo struct {
-	Name    string       `json:"name"`
-	Host    string       `json:"host"`
-	Port    int          `json:"port"`
-	Session *SessionInfo `json:"session,omitempty"`
+	Name          string            `json:"name"`
+	Host          string            `json:"host"`
+	Port          int               `json:"port"`
+	Session       *SessionInfo      `json:"session,omitempty"`
+	CanInventory  *CanInventory     `json:"can_inventory,omitempty"`
+	CanBlocks     map[string]string `json:"can_blocks,omitempty"`
+	RbusBlocks    map[string]string `json:"rbus_blocks,omitempty"`
+	LocoNetBlocks map[string]string `json:"loconet_blocks,omitempty"`
+	Routes        []RouteInfo       `json:"routes,omitempty"`
 }
 
 type SessionInfo struct {
@@ -316,6 +321,13 @@ func saveContexts(store *ContextStore) error {
 }
 
 func saveSessionInfo(sess *SessionInfo, ctx *ContextInfo) error {
+	return updateContext(ctx.Name, func(c *ContextInfo) {
+		c.Session = sess
+	})
+}
+
+// updateContext applies update to the saved context name and saves the store.
+func updateContext(name string, update func(*ContextInfo)) error {
 	store, err := loadContexts()
 	if err != nil {
 		return err
@@ -323,14 +335,14 @@ func saveSessionInfo(sess *SessionInfo, ctx *ContextInfo) error {
 
 	found := false
 	for i := range store.Contexts {
-		if store.Contexts[i].Name == ctx.Name {
+		if store.Contexts[i].Name == name {
 			found = true
-			store.Contexts[i].Session = sess
+			update(&store.Contexts[i])
 			break
 		}
 	}
 	if !found {
-		return fmt.Errorf("context %q not found in saved contexts", ctx.Name)
+		return fmt.Errorf("context %q not found in saved contexts", name)
 	}
 
 	if err := saveContexts(store); err != nil {
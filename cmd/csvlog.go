@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/trains-io/z21.go"
+)
+
+const (
+	DEFAULT_LOG_MAX_SIZE  int = 10 // MB
+	DEFAULT_LOG_MAX_FILES int = 5
+)
+
+var systemLogHeader = []string{
+	"time",
+	"main_current_ma",
+	"prog_current_ma",
+	"filtered_main_current_ma",
+	"temperature_c",
+	"supply_voltage_mv",
+	"vcc_voltage_mv",
+	"central_state",
+	"central_state_ex",
+}
+
+// systemLog appends the system state to a CSV file. Once the file exceeds
+// maxSize bytes it is rotated to path.1, path.1 to path.2 and so on, keeping
+// maxFiles rotated files. A zero maxSize disables the rotation.
+type systemLog struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	f    *os.File
+	w    *csv.Writer
+	size int64
+}
+
+func openSystemLog(path string, maxSize int64, maxFiles int) (*systemLog, error) {
+	l := &systemLog{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// open opens the log for appending, the header is written to new files.
+func (l *systemLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	l.f = f
+	l.w = csv.NewWriter(f)
+	l.size = info.Size()
+	if l.size == 0 {
+		return l.write(systemLogHeader)
+	}
+	return nil
+}
+
+// Write appends a row with the system state d read at t.
+func (l *systemLog) Write(t time.Time, d *z21.SysData) error {
+	if l.maxSize > 0 && l.size >= l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	return l.write([]string{
+		t.Format(time.RFC3339Nano),
+		strconv.Itoa(int(d.MainCurrent)),
+		strconv.Itoa(int(d.ProgCurrent)),
+		strconv.Itoa(int(d.FilteredMainCurrent)),
+		strconv.Itoa(int(d.Temperature)),
+		strconv.Itoa(int(d.SupplyVoltage)),
+		strconv.Itoa(int(d.VccVoltage)),
+		fmt.Sprintf("0x%02x", uint8(d.CentralState)),
+		fmt.Sprintf("0x%02x", uint8(d.CentralStateEx)),
+	})
+}
+
+// write writes and flushes a row, so that no row is lost when the watch is
+// interrupted.
+func (l *systemLog) write(row []string) error {
+	if err := l.w.Write(row); err != nil {
+		return err
+	}
+	l.w.Flush()
+	if err := l.w.Error(); err != nil {
+		return err
+	}
+
+	info, err := l.f.Stat()
+	if err != nil {
+		return err
+	}
+	l.size = info.Size()
+	return nil
+}
+
+func (l *systemLog) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+
+	os.Remove(fmt.Sprintf("%s.%d", l.path, l.maxFiles))
+	for i := l.maxFiles - 1; i > 0; i-- {
+		old := fmt.Sprintf("%s.%d", l.path, i)
+		if _, err := os.Stat(old); err == nil {
+			if err := os.Rename(old, fmt.Sprintf("%s.%d", l.path, i+1)); err != nil {
+				return err
+			}
+		}
+	}
+	if l.maxFiles > 0 {
+		if err := os.Rename(l.path, l.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(l.path); err != nil {
+		return err
+	}
+
+	return l.open()
+}
+
+func (l *systemLog) Close() error {
+	return l.f.Close()
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/trains-io/z21.go"
+)
+
+// readSystemLog returns the rows of a log file without its header.
+func readSystemLog(t *testing.T, path string) [][]string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) == 0 || rows[0][0] != systemLogHeader[0] {
+		t.Fatalf("%s: missing header", path)
+	}
+	return rows[1:]
+}
+
+func TestSystemLogRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "system.csv")
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// every write exceeds the size, so that each row ends up in its own file
+	l, err := openSystemLog(path, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 5 {
+		if err := l.Write(start.Add(time.Duration(i)*time.Second), &z21.SysData{MainCurrent: uint16(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, name := range []string{path, path + ".1", path + ".2"} {
+		rows := readSystemLog(t, name)
+		if len(rows) != 1 {
+			t.Fatalf("%s: %d rows, want 1", name, len(rows))
+		}
+		want := start.Add(time.Duration(4-i) * time.Second).Format(time.RFC3339Nano)
+		if rows[0][0] != want || rows[0][1] != fmt.Sprint(4-i) {
+			t.Errorf("%s: row %q, want time %s and current %d", name, rows[0], want, 4-i)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("%s.3 exists beyond max files", path)
+	}
+}
+
+func TestSystemLogRotateWithoutFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "system.csv")
+
+	l, err := openSystemLog(path, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		if err := l.Write(time.Now(), &z21.SysData{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if rows := readSystemLog(t, path); len(rows) != 1 {
+		t.Errorf("%d rows, want 1", len(rows))
+	}
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Errorf("%s.1 exists without rotated files", path)
+	}
+}
@@ -0,0 +1,684 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+	"github.com/trains-io/z21.go"
+)
+
+const (
+	DEFAULT_CV_TIMEOUT time.Duration = 5 * time.Second
+	DEFAULT_CV_RETRIES int           = 3
+	DEFAULT_CV_RANGE   string        = "1-256"
+)
+
+const (
+	CV29_DIRECTION   uint8 = 0x01 // bit 0
+	CV29_SPEED_STEPS uint8 = 0x02 // bit 1
+	CV29_ANALOG      uint8 = 0x04 // bit 2
+	CV29_RAILCOM     uint8 = 0x08 // bit 3
+	CV29_SPEED_TABLE uint8 = 0x10 // bit 4
+	CV29_LONG_ADDR   uint8 = 0x20 // bit 5
+)
+
+var (
+	errCvNack         = errors.New("no acknowledge from decoder")
+	errCvShortCircuit = errors.New("short circuit on programming track")
+)
+
+var decoderCmd = &cobra.Command{
+	Use:     "decoder",
+	Aliases: []string{"dec"},
+	Short:   "Read and write loco decoder CVs",
+}
+
+// DecoderBackup is the file format written by `decoder backup`.
+type DecoderBackup struct {
+	Address      uint16    `json:"address"`
+	Manufacturer uint8     `json:"manufacturer"`
+	Version      uint8     `json:"version"`
+	Date         time.Time `json:"date"`
+	CVs          []CVValue `json:"cvs"`
+}
+
+type CVValue struct {
+	CV    uint16 `json:"cv"`
+	Value uint8  `json:"value"`
+}
+
+// ---------- subcommands ----------
+
+// id
+var decoderIdCmd = &cobra.Command{
+	Use:   "id",
+	Short: "Identify the decoder on the programming track",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		retries, _ := cmd.Flags().GetInt("retries")
+
+		app := GetAppContext(cmd)
+		if app == nil || app.Conn == nil {
+			return fmt.Errorf("Z21 connection not initialized")
+		}
+
+		access, err := newCVAccess(app, 0, true, retries)
+		if err != nil {
+			return err
+		}
+		defer access.close()
+
+		cvs := map[uint16]uint8{}
+		for _, cv := range []uint16{1, 7, 8, 17, 18, 29} {
+			v, err := access.read(cv)
+			if err != nil {
+				return err
+			}
+			cvs[cv] = v
+		}
+
+		// index CVs are only implemented by some manufacturers
+		index := []string{}
+		for _, cv := range []uint16{31, 32} {
+			v, err := access.read(cv)
+			if err != nil {
+				index = append(index, "-")
+				continue
+			}
+			index = append(index, fmt.Sprintf("%d", v))
+		}
+
+		printDecoderId(cvs, strings.Join(index, "/"))
+		return nil
+	},
+}
+
+func printDecoderId(cvs map[uint16]uint8, index string) {
+	cv29 := cvs[29]
+
+	address := fmt.Sprintf("%d (short)", cvs[1])
+	if cv29&CV29_LONG_ADDR != 0 {
+		long := uint16(cvs[17]&0x3f)<<8 | uint16(cvs[18])
+		address = fmt.Sprintf("%d (long)", long)
+	}
+
+	steps := "14"
+	if cv29&CV29_SPEED_STEPS != 0 {
+		steps = "28/128"
+	}
+
+	direction := "normal"
+	if cv29&CV29_DIRECTION != 0 {
+		direction = "reversed"
+	}
+
+	fmt.Printf("Decoder: %s (version %d)\n", manufacturerName(cvs[8]), cvs[7])
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.Style().Options.DrawBorder = false
+	t.Style().Options.SeparateColumns = false
+	t.AppendHeader(table.Row{"Setting", "Value"})
+	t.AppendRows([]table.Row{
+		{"Address", address},
+		{"Speed Steps", steps},
+		{"Direction", direction},
+		{"Analog Mode", formatOnOff(cv29&CV29_ANALOG != 0)},
+		{"RailCom", formatOnOff(cv29&CV29_RAILCOM != 0)},
+		{"Speed Table", formatOnOff(cv29&CV29_SPEED_TABLE != 0)},
+		{"CV29", fmt.Sprintf("0x%02x", cv29)},
+		{"Index (CV31/32)", index},
+	})
+	t.Render()
+}
+
+func formatOnOff(on bool) string {
+	if on {
+		return STATUS_ON
+	}
+	return STATUS_OFF
+}
+
+// backup ADDR [--cvs RANGE] [--output | -o FILE] [--resume | --force] [--prog] [--retries N]
+var decoderBackupCmd = &cobra.Command{
+	Use:   "backup ADDR",
+	Short: "Read decoder CVs into a backup file",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		val, err := strconv.ParseUint(args[0], 0, 16)
+		if err != nil {
+			return err
+		}
+		addr := uint16(val)
+		rng, _ := cmd.Flags().GetString("cvs")
+		out, _ := cmd.Flags().GetString("output")
+		prog, _ := cmd.Flags().GetBool("prog")
+		retries, _ := cmd.Flags().GetInt("retries")
+		resume, _ := cmd.Flags().GetBool("resume")
+		force, _ := cmd.Flags().GetBool("force")
+
+		if resume && force {
+			return fmt.Errorf("--resume and --force are mutually exclusive")
+		}
+
+		cvs, err := parseCVRange(rng)
+		if err != nil {
+			return err
+		}
+		if out == "" {
+			out = fmt.Sprintf("decoder-%d.json", addr)
+		}
+
+		app := GetAppContext(cmd)
+		if app == nil || app.Conn == nil {
+			return fmt.Errorf("Z21 connection not initialized")
+		}
+
+		// an existing backup may hold the values of a since changed decoder
+		var backup *DecoderBackup
+		if !force {
+			if backup, err = loadDecoderBackup(out); err != nil {
+				return err
+			}
+		}
+		switch {
+		case backup == nil:
+			if resume {
+				return fmt.Errorf("backup file %s not found", out)
+			}
+			backup = &DecoderBackup{Address: addr, Date: time.Now()}
+		case !resume:
+			return fmt.Errorf("%s exists, use --resume to continue the backup or --force to overwrite it", out)
+		case backup.Address != addr:
+			return fmt.Errorf("%s holds a backup of address %d", out, backup.Address)
+		default:
+			fmt.Printf("Resuming backup %s (%d CVs done)\n", out, len(backup.CVs))
+		}
+
+		access, err := newCVAccess(app, addr, prog, retries)
+		if err != nil {
+			return err
+		}
+		defer access.close()
+
+		if backup.Manufacturer == 0 {
+			if backup.Manufacturer, err = access.read(8); err != nil {
+				return err
+			}
+			if backup.Version, err = access.read(7); err != nil {
+				return err
+			}
+		}
+
+		done := map[uint16]bool{}
+		for _, v := range backup.CVs {
+			done[v.CV] = true
+		}
+
+		skipped := 0
+		for i, cv := range cvs {
+			fmt.Printf("\rReading CV %d (%d/%d) ...", cv, i+1, len(cvs))
+			if done[cv] {
+				continue
+			}
+
+			value, err := access.read(cv)
+			if errors.Is(err, errCvNack) {
+				skipped++
+				continue
+			}
+			if err != nil {
+				fmt.Println()
+				return err
+			}
+
+			backup.CVs = append(backup.CVs, CVValue{CV: cv, Value: value})
+			if err := saveDecoderBackup(out, backup); err != nil {
+				fmt.Println()
+				return err
+			}
+		}
+		fmt.Println()
+
+		if err := saveDecoderBackup(out, backup); err != nil {
+			return err
+		}
+
+		fmt.Printf("Decoder backup saved to %s (%d CVs, %d not supported)\n", out, len(backup.CVs), skipped)
+		return nil
+	},
+}
+
+// restore FILE [--prog] [--from CV] [--retries N]
+var decoderRestoreCmd = &cobra.Command{
+	Use:   "restore FILE",
+	Short: "Write decoder CVs from a backup file",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		prog, _ := cmd.Flags().GetBool("prog")
+		from, _ := cmd.Flags().GetUint16("from")
+		retries, _ := cmd.Flags().GetInt("retries")
+
+		backup, err := loadDecoderBackup(args[0])
+		if err != nil {
+			return err
+		}
+		if backup == nil {
+			return fmt.Errorf("backup file %s not found", args[0])
+		}
+
+		app := GetAppContext(cmd)
+		if app == nil || app.Conn == nil {
+			return fmt.Errorf("Z21 connection not initialized")
+		}
+
+		cvs := restoreOrder(backup.CVs)
+		if from != 0 {
+			i := slices.IndexFunc(cvs, func(v CVValue) bool { return v.CV == from })
+			if i < 0 {
+				return fmt.Errorf("CV%d is not restored from %s", from, args[0])
+			}
+			cvs = cvs[i:]
+		}
+
+		access, err := newCVAccess(app, backup.Address, prog, retries)
+		if err != nil {
+			return err
+		}
+		defer access.close()
+
+		warnDecoderMismatch(access, backup)
+
+		written := 0
+		for i, v := range cvs {
+			fmt.Printf("\rWriting CV %d (%d/%d) ...", v.CV, i+1, len(cvs))
+			if err := access.write(v.CV, v.Value); err != nil {
+				fmt.Println()
+				return fmt.Errorf("%w (resume with --from %d)", err, v.CV)
+			}
+			written++
+		}
+		fmt.Println()
+
+		fmt.Printf("Decoder restored from %s (%d CVs written)\n", args[0], written)
+		return nil
+	},
+}
+
+func warnDecoderMismatch(access *cvAccess, backup *DecoderBackup) {
+	manufacturer, err := access.read(8)
+	if err != nil {
+		fmt.Printf("Warning: unable to verify decoder manufacturer: %s\n", err)
+		return
+	}
+	version, err := access.read(7)
+	if err != nil {
+		fmt.Printf("Warning: unable to verify decoder version: %s\n", err)
+		return
+	}
+
+	if manufacturer != backup.Manufacturer {
+		fmt.Printf("Warning: decoder manufacturer %d differs from backup (%d)\n", manufacturer, backup.Manufacturer)
+	}
+	if version != backup.Version {
+		fmt.Printf("Warning: decoder version %d differs from backup (%d)\n", version, backup.Version)
+	}
+}
+
+// restoreOrder drops the read-only CV7 and CV8 (writing CV8 resets most
+// decoders) and moves the addressing CVs to the end, so a restore on the
+// main track keeps talking to the same address until the very last writes.
+func restoreOrder(cvs []CVValue) []CVValue {
+	last := map[uint16]int{1: 1, 17: 2, 18: 3, 29: 4}
+
+	out := []CVValue{}
+	for _, v := range cvs {
+		if v.CV == 7 || v.CV == 8 {
+			continue
+		}
+		out = append(out, v)
+	}
+
+	sort.SliceStable(out, func(i, j int) bool {
+		li, lj := last[out[i].CV], last[out[j].CV]
+		if li != lj {
+			return li < lj
+		}
+		return out[i].CV < out[j].CV
+	})
+	return out
+}
+
+func parseCVRange(s string) ([]uint16, error) {
+	cvs := []uint16{}
+	for _, part := range strings.Split(s, ",") {
+		lo, hi, found := strings.Cut(strings.TrimSpace(part), "-")
+		if !found {
+			hi = lo
+		}
+
+		start, err := strconv.ParseUint(lo, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CV range %q", s)
+		}
+		end, err := strconv.ParseUint(hi, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CV range %q", s)
+		}
+		if start < 1 || end > 1024 || start > end {
+			return nil, fmt.Errorf("invalid CV range %q (1-1024)", s)
+		}
+
+		for cv := start; cv <= end; cv++ {
+			cvs = append(cvs, uint16(cv))
+		}
+	}
+	return cvs, nil
+}
+
+func loadDecoderBackup(path string) (*DecoderBackup, error) {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	backup := &DecoderBackup{}
+	if err := json.Unmarshal(data, backup); err != nil {
+		return nil, err
+	}
+	return backup, nil
+}
+
+func saveDecoderBackup(path string, backup *DecoderBackup) error {
+	sort.Slice(backup.CVs, func(i, j int) bool {
+		return backup.CVs[i].CV < backup.CVs[j].CV
+	})
+	data, err := json.MarshalIndent(backup, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// ---------- CV access ----------
+
+// cvAccess reads and writes CVs either on the programming track or, for a
+// loco address, on the main track (POM). POM reads require RailCom.
+type cvAccess struct {
+	app     *AppContext
+	address uint16
+	prog    bool
+	retries int
+	track   z21.Mask8 // track status before the programming mode
+}
+
+func newCVAccess(app *AppContext, address uint16, prog bool, retries int) (*cvAccess, error) {
+	a := &cvAccess{app: app, address: address, prog: prog, retries: retries}
+	if prog {
+		st, err := getTrackStatus(app.Conn)
+		if err != nil {
+			return nil, err
+		}
+		if st == nil {
+			return nil, fmt.Errorf("no track status received")
+		}
+		a.track = st.Mask
+	}
+	return a, nil
+}
+
+func (a *cvAccess) read(cv uint16) (uint8, error) {
+	var msg z21.Serializable = &cvPomRead{Address: a.address, CV: cv}
+	if a.prog {
+		msg = &cvRead{CV: cv}
+	}
+
+	var err error
+	for i := 0; i <= a.retries; i++ {
+		var f *z21.Frame
+		f, err = ReqFrame(a.app, msg, DEFAULT_CV_TIMEOUT, isCvReply)
+		if err != nil {
+			continue
+		}
+
+		var res *cvResult
+		res, err = decodeCvReply(f)
+		if errors.Is(err, errCvShortCircuit) {
+			break
+		}
+		if err == nil && res.CV != cv {
+			err = fmt.Errorf("unexpected reply for CV%d", res.CV)
+		}
+		if err == nil {
+			return res.Value, nil
+		}
+	}
+	return 0, fmt.Errorf("CV%d: %w", cv, err)
+}
+
+func (a *cvAccess) write(cv uint16, value uint8) error {
+	if !a.prog {
+		// POM writes are not acknowledged
+		_, err := Req(a.app.Conn, &cvPomWrite{Address: a.address, CV: cv, Value: value})
+		return err
+	}
+
+	var err error
+	for i := 0; i <= a.retries; i++ {
+		var f *z21.Frame
+		f, err = ReqFrame(a.app, &cvWrite{CV: cv, Value: value}, DEFAULT_CV_TIMEOUT, isCvReply)
+		if err != nil {
+			continue
+		}
+
+		var res *cvResult
+		res, err = decodeCvReply(f)
+		if errors.Is(err, errCvShortCircuit) {
+			break
+		}
+		if err == nil && (res.CV != cv || res.Value != value) {
+			err = fmt.Errorf("verify failed")
+		}
+		if err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("CV%d: %w", cv, err)
+}
+
+// close ends the programming mode the Z21 enters on the first service mode
+// request by restoring the track power from before the programming mode.
+func (a *cvAccess) close() {
+	if !a.prog {
+		return
+	}
+	if a.track.Has(z21.TRACK_VOLTAGE_OFF) {
+		setTrackPower(a.app, false)
+		return
+	}
+	if err := setTrackPower(a.app, true); err != nil {
+		return
+	}
+	if a.track.Has(z21.EMERGENCY_STOP) {
+		Req(a.app.Conn, &z21.Stop{})
+	}
+}
+
+func isCvReply(f *z21.Frame) bool {
+	return isXFrame(f, z21.LAN_X_CV_RESULT, 0x14) ||
+		isXFrame(f, z21.LAN_X_61, z21.LAN_X_CV_NACK) ||
+		isXFrame(f, z21.LAN_X_61, z21.LAN_X_CV_NACK_SC)
+}
+
+func decodeCvReply(f *z21.Frame) (*cvResult, error) {
+	switch {
+	case isXFrame(f, z21.LAN_X_61, z21.LAN_X_CV_NACK):
+		return nil, errCvNack
+	case isXFrame(f, z21.LAN_X_61, z21.LAN_X_CV_NACK_SC):
+		return nil, errCvShortCircuit
+	}
+
+	res := &cvResult{}
+	if err := res.Unpack(f.Payload); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ---------- messages ----------
+
+// LAN_X_CV_READ
+type cvRead struct {
+	CV uint16
+}
+
+func (m *cvRead) Pack() ([]byte, error) {
+	adr := m.CV - 1
+	b := []byte{z21.LAN_X_23, z21.LAN_X_CV_READ, byte(adr >> 8), byte(adr)}
+	return append(b, xor(b)), nil
+}
+
+func (m *cvRead) Unpack(data []byte) error {
+	return nil
+}
+
+func (m *cvRead) EncapType() uint16 {
+	return z21.LAN_X
+}
+
+func (m *cvRead) Key() (string, bool) {
+	return "", false
+}
+
+// LAN_X_CV_WRITE
+type cvWrite struct {
+	CV    uint16
+	Value uint8
+}
+
+func (m *cvWrite) Pack() ([]byte, error) {
+	adr := m.CV - 1
+	b := []byte{z21.LAN_X_24, z21.LAN_X_CV_WRITE, byte(adr >> 8), byte(adr), m.Value}
+	return append(b, xor(b)), nil
+}
+
+func (m *cvWrite) Unpack(data []byte) error {
+	return nil
+}
+
+func (m *cvWrite) EncapType() uint16 {
+	return z21.LAN_X
+}
+
+func (m *cvWrite) Key() (string, bool) {
+	return "", false
+}
+
+// LAN_X_CV_POM_READ_BYTE
+type cvPomRead struct {
+	Address uint16
+	CV      uint16
+}
+
+func (m *cvPomRead) Pack() ([]byte, error) {
+	return packPom(z21.LAN_X_CV_POM_READ_BYTE, m.Address, m.CV, 0), nil
+}
+
+func (m *cvPomRead) Unpack(data []byte) error {
+	return nil
+}
+
+func (m *cvPomRead) EncapType() uint16 {
+	return z21.LAN_X
+}
+
+func (m *cvPomRead) Key() (string, bool) {
+	return "", false
+}
+
+// LAN_X_CV_POM_WRITE_BYTE
+type cvPomWrite struct {
+	Address uint16
+	CV      uint16
+	Value   uint8
+}
+
+func (m *cvPomWrite) Pack() ([]byte, error) {
+	return packPom(z21.LAN_X_CV_POM_WRITE_BYTE, m.Address, m.CV, m.Value), nil
+}
+
+func (m *cvPomWrite) Unpack(data []byte) error {
+	return nil
+}
+
+func (m *cvPomWrite) EncapType() uint16 {
+	return z21.LAN_X
+}
+
+func (m *cvPomWrite) Key() (string, bool) {
+	return "", false
+}
+
+func packPom(option uint8, address, cv uint16, value uint8) []byte {
+	adr := cv - 1
+	b := []byte{
+		z21.LAN_X_E6,
+		z21.LAN_X_E6_30,
+		byte(address>>8) & 0x3f,
+		byte(address),
+		option | byte(adr>>8)&0x03,
+		byte(adr),
+		value,
+	}
+	return append(b, xor(b))
+}
+
+// LAN_X_CV_RESULT
+type cvResult struct {
+	CV    uint16
+	Value uint8
+}
+
+func (m *cvResult) Unpack(data []byte) error {
+	if len(data) < 5 {
+		return fmt.Errorf("truncated CV result")
+	}
+	m.CV = uint16(data[2])<<8 | uint16(data[3]) + 1
+	m.Value = data[4]
+	return nil
+}
+
+// ---------- init ----------
+
+func init() {
+	decoderCmd.AddCommand(
+		decoderIdCmd,
+		decoderBackupCmd,
+		decoderRestoreCmd,
+	)
+
+	decoderIdCmd.Flags().Int("retries", DEFAULT_CV_RETRIES, "retries per CV")
+	decoderBackupCmd.Flags().String("cvs", DEFAULT_CV_RANGE, "CVs to read, e.g. 1-256 or 1-10,29")
+	decoderBackupCmd.Flags().StringP("output", "o", "", "backup file (default decoder-ADDR.json)")
+	decoderBackupCmd.Flags().Bool("resume", false, "continue an interrupted backup in the output file")
+	decoderBackupCmd.Flags().Bool("force", false, "overwrite an existing output file")
+	decoderBackupCmd.Flags().Bool("prog", false, "use the programming track instead of POM")
+	decoderBackupCmd.Flags().Int("retries", DEFAULT_CV_RETRIES, "retries per CV")
+	decoderRestoreCmd.Flags().Bool("prog", false, "use the programming track instead of POM")
+	decoderRestoreCmd.Flags().Uint16("from", 0, "resume an interrupted restore from this CV")
+	decoderRestoreCmd.Flags().Int("retries", DEFAULT_CV_RETRIES, "retries per CV")
+}
@@ -0,0 +1,280 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/cobra"
+	"github.com/trains-io/z21.go"
+)
+
+const (
+	DEFAULT_EXPORTER_ADDR     string        = ":9121"
+	DEFAULT_EXPORTER_INTERVAL time.Duration = 5 * time.Second
+
+	METRICS_NAMESPACE string = "z21"
+)
+
+// exporter [--listen ADDR] [--interval DURATION]
+var exporterCmd = &cobra.Command{
+	Use:   "exporter",
+	Short: "Export Z21 metrics to Prometheus",
+	Long: `Serve the system state, the track status, the CAN port occupancy and the
+request latencies of the Z21 as Prometheus metrics on /metrics.
+
+The system and track status are polled every --interval, the CAN occupancy is
+updated from the broadcasts given with --sub.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		addr, _ := cmd.Flags().GetString("listen")
+		interval, _ := cmd.Flags().GetDuration("interval")
+		names, _ := cmd.Flags().GetStringSlice("sub")
+
+		if interval <= 0 {
+			return fmt.Errorf("invalid interval %s", interval)
+		}
+
+		var flags uint32
+		for _, name := range names {
+			f, err := getSub(name)
+			if err != nil {
+				return err
+			}
+			flags |= f
+		}
+
+		app := GetAppContext(cmd)
+		if app == nil || app.Conn == nil {
+			return fmt.Errorf("Z21 connection not initialized")
+		}
+
+		if err := subscribe(app.Conn, flags); err != nil {
+			return err
+		}
+
+		reg := prometheus.NewRegistry()
+		e := &metricsExporter{app: app, metrics: newZ21Metrics(reg)}
+
+		// the CAN detectors answer with the state of all ports, the
+		// replies are read by the pump as broadcasts
+		if flags&uint32(z21.CAN_DETECTOR_UPDATES) != 0 {
+			if _, err := Req(app.Conn, &z21.CanDetector{NetworkID: z21.CAN_BROADCAST_NID}); err != nil {
+				return err
+			}
+		}
+
+		go e.pump()
+		go e.poll(interval)
+
+		http.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+		fmt.Printf("Exporting Z21 %q metrics on http://%s/metrics\n", app.ContextName, addr)
+		return http.ListenAndServe(addr, nil)
+	},
+}
+
+type z21Metrics struct {
+	up                  prometheus.Gauge
+	mainCurrent         prometheus.Gauge
+	progCurrent         prometheus.Gauge
+	filteredMainCurrent prometheus.Gauge
+	temperature         prometheus.Gauge
+	supplyVoltage       prometheus.Gauge
+	vccVoltage          prometheus.Gauge
+	track               *prometheus.GaugeVec
+	shortCircuits       prometheus.Counter
+	canPort             *prometheus.GaugeVec
+	requestDuration     *prometheus.HistogramVec
+	requestTimeouts     *prometheus.CounterVec
+	requestErrors       *prometheus.CounterVec
+}
+
+func newZ21Metrics(reg prometheus.Registerer) *z21Metrics {
+	gauge := func(name, help string) prometheus.Gauge {
+		return prometheus.NewGauge(prometheus.GaugeOpts{Namespace: METRICS_NAMESPACE, Name: name, Help: help})
+	}
+
+	m := &z21Metrics{
+		up:                  gauge("up", "Whether the last poll of the Z21 succeeded."),
+		mainCurrent:         gauge("main_current_amperes", "Current on the main track."),
+		progCurrent:         gauge("prog_current_amperes", "Current on the programming track."),
+		filteredMainCurrent: gauge("filtered_main_current_amperes", "Smoothed current on the main track."),
+		temperature:         gauge("temperature_celsius", "Internal temperature of the command station."),
+		supplyVoltage:       gauge("supply_voltage_volts", "Supply voltage."),
+		vccVoltage:          gauge("vcc_voltage_volts", "Internal voltage, identical to the track voltage."),
+		track: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "track_status",
+			Help:      "Track status flags of the command station.",
+		}, []string{"flag"}),
+		shortCircuits: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "short_circuits_total",
+			Help:      "Short circuits broadcast by the Z21.",
+		}),
+		canPort: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "can_port_occupied",
+			Help:      "Occupancy of the ports of the CAN occupancy detectors.",
+		}, []string{"netid", "port", "block"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "request_duration_seconds",
+			Help:      "Latency of the requests to the Z21.",
+			Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5},
+		}, []string{"request"}),
+		requestTimeouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "request_timeouts_total",
+			Help:      "Requests to the Z21 that were not answered in time.",
+		}, []string{"request"}),
+		requestErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: METRICS_NAMESPACE,
+			Name:      "request_errors_total",
+			Help:      "Requests to the Z21 that failed for other reasons than a timeout.",
+		}, []string{"request"}),
+	}
+
+	reg.MustRegister(
+		m.up,
+		m.mainCurrent,
+		m.progCurrent,
+		m.filteredMainCurrent,
+		m.temperature,
+		m.supplyVoltage,
+		m.vccVoltage,
+		m.track,
+		m.shortCircuits,
+		m.canPort,
+		m.requestDuration,
+		m.requestTimeouts,
+		m.requestErrors,
+	)
+	return m
+}
+
+func (m *z21Metrics) setSystemStatus(d *z21.SysData) {
+	m.mainCurrent.Set(float64(d.MainCurrent) / 1000)
+	m.progCurrent.Set(float64(d.ProgCurrent) / 1000)
+	m.filteredMainCurrent.Set(float64(d.FilteredMainCurrent) / 1000)
+	m.temperature.Set(float64(d.Temperature))
+	m.supplyVoltage.Set(float64(d.SupplyVoltage) / 1000)
+	m.vccVoltage.Set(float64(d.VccVoltage) / 1000)
+}
+
+func (m *z21Metrics) setTrackStatus(mask z21.Mask8) {
+	for flag, bit := range map[string]uint8{
+		"emergency_stop":    z21.EMERGENCY_STOP,
+		"track_voltage_off": z21.TRACK_VOLTAGE_OFF,
+		"short_circuit":     z21.SHORT_CIRCUIT,
+		"programming_mode":  z21.PROGRAMMING_MODE_ACTIVE,
+	} {
+		m.track.WithLabelValues(flag).Set(boolToFloat(mask.Has(bit)))
+	}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// metricsExporter polls the Z21 and reads its broadcasts on the same
+// connection, the requests and the broadcasts are serialized by mu.
+type metricsExporter struct {
+	app     *AppContext
+	metrics *z21Metrics
+	mu      sync.Mutex // held while the Z21 is polled or a broadcast is read
+}
+
+// poll updates the system and track status every interval.
+func (e *metricsExporter) poll(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for ; ; <-t.C {
+		e.mu.Lock()
+		var data *z21.SysData
+		e.observe("system_state", func() (err error) {
+			data, err = getSystemStatus(e.app.Conn)
+			return err
+		})
+		var st *z21.Status
+		e.observe("track_status", func() (err error) {
+			st, err = getTrackStatus(e.app.Conn)
+			return err
+		})
+		e.mu.Unlock()
+
+		if data != nil {
+			e.metrics.setSystemStatus(data)
+		}
+		if st != nil {
+			e.metrics.setTrackStatus(st.Mask)
+		}
+		e.metrics.up.Set(boolToFloat(data != nil && st != nil))
+	}
+}
+
+// observe runs the request f and records its latency and failure.
+func (e *metricsExporter) observe(request string, f func() error) error {
+	start := time.Now()
+	err := f()
+	e.metrics.requestDuration.WithLabelValues(request).Observe(time.Since(start).Seconds())
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		e.metrics.requestTimeouts.WithLabelValues(request).Inc()
+	case err != nil:
+		e.metrics.requestErrors.WithLabelValues(request).Inc()
+	}
+	return err
+}
+
+// pump updates the metrics from the broadcasts.
+func (e *metricsExporter) pump() {
+	rbus := map[uint8]*rbusData{}
+	readBroadcasts(e.app, &e.mu, nil,
+		func(ev z21.Serializable) {
+			switch v := ev.(type) {
+			case *z21.SysData:
+				e.metrics.setSystemStatus(v)
+			case *z21.Status:
+				e.metrics.setTrackStatus(v.Mask)
+			case *z21.TrackPower:
+				e.metrics.track.WithLabelValues("track_voltage_off").Set(boolToFloat(!v.On))
+			}
+			e.update(newStreamEvents(e.app, ev))
+		},
+		func(f *z21.Frame) {
+			e.update(frameStreamEvents(f, rbus))
+		},
+	)
+}
+
+func (e *metricsExporter) update(events []streamEvent) {
+	for _, ev := range events {
+		switch v := ev.Data.(type) {
+		case map[string]bool:
+			if v["short_circuit"] {
+				e.metrics.shortCircuits.Inc()
+				e.metrics.track.WithLabelValues("short_circuit").Set(1)
+			}
+		case canPortEventReport:
+			e.metrics.canPort.WithLabelValues(fmt.Sprintf("0x%04X", v.NetworkID), fmt.Sprint(v.Port), v.Block).Set(boolToFloat(v.Busy))
+		}
+	}
+}
+
+// ---------- init ----------
+
+func init() {
+	exporterCmd.Flags().String("listen", DEFAULT_EXPORTER_ADDR, "address of the metrics server")
+	exporterCmd.Flags().Duration("interval", DEFAULT_EXPORTER_INTERVAL, "interval of the system and track status polls")
+	exporterCmd.Flags().StringSlice("sub", []string{"TRACK_UPDATES", "CAN_DETECTOR_UPDATES"}, "broadcasts to subscribe")
+}
@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/trains-io/z21.go"
+)
+
+const (
+	DEFAULT_FRAME_BUF_SIZE int           = 500
+	DEFAULT_REQ_TIMEOUT    time.Duration = 500 * time.Millisecond
+	DEFAULT_BROADCAST_POLL time.Duration = 100 * time.Millisecond
+)
+
+// frameTap wraps the dialer handed to z21.Connect. The z21.go listener drops
+// every frame it cannot decode (R-Bus, RailCom, LocoNet, CV results, ...), so
+// the connection returned by the tap forwards a copy of those frames to the
+// CLI before the library sees the datagram.
+type frameTap struct {
+	dialer z21.CustomDialer
+	frames chan<- z21.Frame
+}
+
+func (t *frameTap) Dial(network, address string) (net.Conn, error) {
+	c, err := t.dialer.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return &tapConn{Conn: c, frames: t.frames}, nil
+}
+
+type tapConn struct {
+	net.Conn
+	frames chan<- z21.Frame
+}
+
+func (c *tapConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.tap(b[:n])
+	}
+	return n, err
+}
+
+func (c *tapConn) tap(datagram []byte) {
+	frames, err := z21.ParseFrames(datagram)
+	if err != nil {
+		return
+	}
+
+	for _, f := range frames {
+		if isDecodable(f) {
+			continue
+		}
+		// the read buffer is reused by the library
+		f.Payload = bytes.Clone(f.Payload)
+		select {
+		case c.frames <- f:
+		default:
+		}
+	}
+}
+
+func isDecodable(f z21.Frame) bool {
+	if f.Header == z21.LAN_X && len(f.Payload) < 2 {
+		return false
+	}
+	// the LocoInfo of z21.go does not decode the loco state
+	if f.Header == z21.LAN_X && f.Payload[0] == z21.LAN_X_LOCO_INFO {
+		return false
+	}
+	_, err := z21.DecodeFrame(f)
+	return err == nil
+}
+
+// ReqFrame sends msg and waits for the first raw frame accepted by match.
+func ReqFrame(app *AppContext, msg z21.Serializable, timeout time.Duration, match func(*z21.Frame) bool) (*z21.Frame, error) {
+	if _, err := Req(app.Conn, msg); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("request timeout")
+		case f := <-app.Frames:
+			if match(&f) {
+				return &f, nil
+			}
+		}
+	}
+}
+
+// readBroadcasts passes the broadcasts to onEvent and onFrame until done is
+// closed. mu is held while waiting for a broadcast, commands hold mu to read
+// their replies from the same connection.
+func readBroadcasts(app *AppContext, mu *sync.Mutex, done <-chan struct{}, onEvent func(z21.Serializable), onFrame func(*z21.Frame)) {
+	for {
+		mu.Lock()
+		select {
+		case <-done:
+			mu.Unlock()
+			return
+		case ev := <-app.Conn.Events():
+			onEvent(ev)
+		case f := <-app.Frames:
+			onFrame(&f)
+		case <-time.After(DEFAULT_BROADCAST_POLL):
+		}
+		mu.Unlock()
+	}
+}
+
+// isXFrame reports whether f is a LAN_X frame starting with the given
+// X-header and DB0.
+func isXFrame(f *z21.Frame, xhdr, db0 uint8) bool {
+	return f.Header == z21.LAN_X &&
+		len(f.Payload) >= 2 &&
+		f.Payload[0] == xhdr &&
+		f.Payload[1] == db0
+}
+
+// xor calculates the X-Bus checksum of data.
+func xor(data []byte) byte {
+	var x byte
+	for _, b := range data {
+		x ^= b
+	}
+	return x
+}
+
+// packLocoAddress encodes a loco address as X-Bus Adr_MSB and Adr_LSB.
+func packLocoAddress(addr uint16) (byte, byte) {
+	msb := byte(addr >> 8)
+	if addr >= 128 {
+		msb |= 0xc0
+	}
+	return msb, byte(addr)
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/trains-io/z21.go"
+)
+
+func TestIsDecodable(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame z21.Frame
+		want  bool
+	}{
+		{
+			name:  "track power off",
+			frame: z21.Frame{Header: z21.LAN_X, Payload: []byte{z21.LAN_X_61, z21.LAN_X_BC_TRACK_POWER_OFF, 0x61}},
+			want:  true,
+		},
+		{
+			name:  "loco info",
+			frame: z21.Frame{Header: z21.LAN_X, Payload: locoInfoFrame(0x00, 0x03, 0x04, 0x85, 0x10, 0x00, 0x00, 0x00)},
+			want:  false,
+		},
+		{
+			name:  "short X-Bus frame",
+			frame: z21.Frame{Header: z21.LAN_X, Payload: []byte{z21.LAN_X_61}},
+			want:  false,
+		},
+		{
+			name:  "R-Bus feedback",
+			frame: z21.Frame{Header: z21.LAN_RMBUS_DATACHANGED, Payload: make([]byte, 11)},
+			want:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		if got := isDecodable(tt.frame); got != tt.want {
+			t.Errorf("%s: isDecodable = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
@@ -74,18 +74,7 @@ var infoCmd = &cobra.Command{
 		}
 
 		if flagAll || flagScope {
-			var s string = ""
-			switch scope.Code {
-			case z21.Z21_NO_LOCK:
-				s = "no lock"
-			case z21.Z21_START_LOCKED:
-				s = "locked"
-			case z21.Z21_START_UNLOCKED:
-				s = "unlocked"
-			default:
-				s = "unknown"
-			}
-			out = append(out, fmt.Sprintf("[%s]", s))
+			out = append(out, fmt.Sprintf("[%s]", formatLockCode(scope.Code)))
 		}
 
 		fmt.Printf("%s\n", strings.Join(out, " "))
@@ -94,6 +83,19 @@ var infoCmd = &cobra.Command{
 	},
 }
 
+func formatLockCode(code uint8) string {
+	switch code {
+	case z21.Z21_NO_LOCK:
+		return "no lock"
+	case z21.Z21_START_LOCKED:
+		return "locked"
+	case z21.Z21_START_UNLOCKED:
+		return "unlocked"
+	default:
+		return "unknown"
+	}
+}
+
 func init() {
 	infoCmd.Flags().BoolVarP(
 		&flagAll,
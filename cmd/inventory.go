@@ -0,0 +1,246 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+)
+
+// CanInventory is the list of CAN detectors saved with a context.
+type CanInventory struct {
+	Saved   time.Time            `json:"saved"`
+	Devices []CanInventoryDevice `json:"devices"`
+}
+
+// CanInventoryDevice is a CAN detector of the inventory. The Z21 LAN protocol
+// has no firmware version of CAN devices, the name is saved instead.
+type CanInventoryDevice struct {
+	NetworkID uint16 `json:"netid"`
+	Address   uint16 `json:"address"`
+	Ports     int    `json:"ports"`
+	Name      string `json:"name,omitempty"`
+}
+
+type canInventoryChange struct {
+	Change  string
+	Device  CanInventoryDevice
+	Details string
+}
+
+var canInventoryCmd = &cobra.Command{
+	Use:     "inventory",
+	Aliases: []string{"inv"},
+	Short:   "Save and compare the list of CAN devices",
+}
+
+// ---------- subcommands ----------
+
+// save [--timeout | -t SECONDS] [--quiet-period DURATION]
+var canInventorySaveCmd = &cobra.Command{
+	Use:   "save",
+	Short: "Discover the CAN devices and save them to the current context",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		quiet, _ := cmd.Flags().GetDuration("quiet-period")
+
+		app := GetAppContext(cmd)
+		if app == nil || app.Conn == nil {
+			return fmt.Errorf("Z21 connection not initialized")
+		}
+
+		fmt.Printf("Discover CAN devices (timeout: %s) ...\n", timeout)
+		inv, err := getCanInventory(app, timeout, quiet)
+		if err != nil {
+			return err
+		}
+
+		err = updateContext(app.ContextName, func(c *ContextInfo) {
+			c.CanInventory = inv
+		})
+		if err != nil {
+			return err
+		}
+
+		printCanInventory(inv)
+		fmt.Printf("\nSaved %d device(s) to context %q\n", len(inv.Devices), app.ContextName)
+		return nil
+	},
+}
+
+// diff [--timeout | -t SECONDS] [--quiet-period DURATION]
+var canInventoryDiffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Discover the CAN devices and compare them with the saved inventory",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		quiet, _ := cmd.Flags().GetDuration("quiet-period")
+
+		app := GetAppContext(cmd)
+		if app == nil || app.Conn == nil {
+			return fmt.Errorf("Z21 connection not initialized")
+		}
+
+		c, err := loadCurrentContext()
+		if err != nil {
+			return err
+		}
+		if c.CanInventory == nil {
+			return fmt.Errorf("no CAN inventory saved, run `z21 can inventory save` first")
+		}
+
+		fmt.Printf("Discover CAN devices (timeout: %s) ...\n", timeout)
+		inv, err := getCanInventory(app, timeout, quiet)
+		if err != nil {
+			return err
+		}
+
+		changes := diffCanInventory(c.CanInventory, inv)
+		if len(changes) == 0 {
+			fmt.Printf("No changes since %s (%d device(s))\n",
+				c.CanInventory.Saved.Format(time.DateTime), len(inv.Devices))
+			return nil
+		}
+
+		fmt.Printf("Changes since %s:\n", c.CanInventory.Saved.Format(time.DateTime))
+		printCanInventoryChanges(changes)
+		return fmt.Errorf("CAN inventory changed: %d difference(s)", len(changes))
+	},
+}
+
+func getCanInventory(app *AppContext, timeout, quiet time.Duration) (*CanInventory, error) {
+	devices, err := discoverCanDevices(app, timeout, quiet, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	inv := &CanInventory{Saved: time.Now()}
+	for _, d := range sortCanDevices(devices, "netid") {
+		// the name is optional, not every device has one
+		name, _ := getCanDescription(app, d.NetworkID)
+		inv.Devices = append(inv.Devices, CanInventoryDevice{
+			NetworkID: d.NetworkID,
+			Address:   d.Address,
+			Ports:     len(d.Ports),
+			Name:      name,
+		})
+	}
+	return inv, nil
+}
+
+// diffCanInventory reports the devices missing from cur, the new devices in
+// cur and the devices whose address or port count changed.
+func diffCanInventory(saved, cur *CanInventory) []canInventoryChange {
+	changes := []canInventoryChange{}
+
+	curByID := map[uint16]CanInventoryDevice{}
+	for _, d := range cur.Devices {
+		curByID[d.NetworkID] = d
+	}
+	savedByID := map[uint16]CanInventoryDevice{}
+	for _, d := range saved.Devices {
+		savedByID[d.NetworkID] = d
+	}
+
+	for _, s := range saved.Devices {
+		c, ok := curByID[s.NetworkID]
+		if !ok {
+			changes = append(changes, canInventoryChange{Change: "missing", Device: s})
+			continue
+		}
+		if c.Address != s.Address {
+			changes = append(changes, canInventoryChange{
+				Change:  "readdressed",
+				Device:  c,
+				Details: fmt.Sprintf("address %d -> %d", s.Address, c.Address),
+			})
+		}
+		if c.Ports != s.Ports {
+			changes = append(changes, canInventoryChange{
+				Change:  "ports",
+				Device:  c,
+				Details: fmt.Sprintf("ports %d -> %d", s.Ports, c.Ports),
+			})
+		}
+	}
+
+	for _, c := range cur.Devices {
+		if _, ok := savedByID[c.NetworkID]; !ok {
+			changes = append(changes, canInventoryChange{Change: "new", Device: c})
+		}
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Device.NetworkID < changes[j].Device.NetworkID
+	})
+	return changes
+}
+
+func printCanInventory(inv *CanInventory) {
+	if len(inv.Devices) == 0 {
+		fmt.Printf("No devices found\n")
+		return
+	}
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.Style().Options.DrawBorder = false
+	t.Style().Options.SeparateColumns = false
+	t.AppendHeader(table.Row{"NetID", "Addr", "Ports", "Name"})
+	for _, d := range inv.Devices {
+		t.AppendRow(
+			table.Row{
+				fmt.Sprintf("0x%04X", d.NetworkID),
+				fmt.Sprintf("%d", d.Address),
+				fmt.Sprintf("%d", d.Ports),
+				formatCanName(d.Name),
+			},
+		)
+	}
+	t.Render()
+}
+
+func printCanInventoryChanges(changes []canInventoryChange) {
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.Style().Options.DrawBorder = false
+	t.Style().Options.SeparateColumns = false
+	t.AppendHeader(table.Row{"Change", "NetID", "Addr", "Name", "Details"})
+	for _, c := range changes {
+		t.AppendRow(
+			table.Row{
+				c.Change,
+				fmt.Sprintf("0x%04X", c.Device.NetworkID),
+				fmt.Sprintf("%d", c.Device.Address),
+				formatCanName(c.Device.Name),
+				c.Details,
+			},
+		)
+	}
+	t.Render()
+}
+
+func formatCanName(name string) string {
+	if name == "" {
+		return "-"
+	}
+	return name
+}
+
+// ---------- init ----------
+
+func init() {
+	canCmd.AddCommand(canInventoryCmd)
+	canInventoryCmd.AddCommand(
+		canInventorySaveCmd,
+		canInventoryDiffCmd,
+	)
+
+	for _, c := range []*cobra.Command{canInventorySaveCmd, canInventoryDiffCmd} {
+		c.Flags().DurationP("timeout", "t", DEFAULT_SCAN_TIMEOUT, "timeout in seconds")
+		c.Flags().Duration("quiet-period", 0, "return once no reply arrived for this period")
+	}
+}
@@ -0,0 +1,690 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+	"github.com/trains-io/z21.go"
+)
+
+const (
+	FEEDBACK_CAN     = "can"
+	FEEDBACK_RBUS    = "rbus"
+	FEEDBACK_LOCONET = "ln"
+)
+
+type blockState uint8
+
+const (
+	BLOCK_UNKNOWN blockState = iota
+	BLOCK_FREE
+	BLOCK_BUSY
+)
+
+var layoutCmd = &cobra.Command{
+	Use:   "layout",
+	Short: "Show the occupancy of named layout blocks",
+}
+
+// ---------- subcommands ----------
+
+// map SOURCE NAME
+var layoutMapCmd = &cobra.Command{
+	Use:   "map SOURCE NAME",
+	Short: "Map a feedback input to a block (can:NETID:PORT, rbus:MODULE:INPUT, ln:ADDR)",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		kind, key, err := parseFeedbackSource(args[0])
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSpace(args[1])
+		if name == "" {
+			return fmt.Errorf("block name must not be empty")
+		}
+
+		c, err := loadCurrentContext()
+		if err != nil {
+			return err
+		}
+
+		err = updateContext(c.Name, func(c *ContextInfo) {
+			blocks := feedbackBlocks(c, kind)
+			if *blocks == nil {
+				*blocks = map[string]string{}
+			}
+			(*blocks)[key] = name
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Block %q <- %s:%s\n", name, kind, key)
+		return nil
+	},
+}
+
+// unmap SOURCE
+var layoutUnmapCmd = &cobra.Command{
+	Use:   "unmap SOURCE",
+	Short: "Remove a feedback input from its block",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		kind, key, err := parseFeedbackSource(args[0])
+		if err != nil {
+			return err
+		}
+
+		c, err := loadCurrentContext()
+		if err != nil {
+			return err
+		}
+		if _, ok := (*feedbackBlocks(c, kind))[key]; !ok {
+			return fmt.Errorf("%s:%s is not mapped to a block", kind, key)
+		}
+
+		err = updateContext(c.Name, func(c *ContextInfo) {
+			delete(*feedbackBlocks(c, kind), key)
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("%s:%s removed\n", kind, key)
+		return nil
+	},
+}
+
+// blocks [--timeout | -t SECONDS] [--json]
+var layoutBlocksCmd = &cobra.Command{
+	Use:   "blocks",
+	Short: "Show the state of all blocks",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		asJSON, _ := cmd.Flags().GetBool("json")
+
+		app := GetAppContext(cmd)
+		if app == nil || app.Conn == nil {
+			return fmt.Errorf("Z21 connection not initialized")
+		}
+
+		c, err := loadCurrentContext()
+		if err != nil {
+			return err
+		}
+		m := newLayoutModel(c)
+		if len(m.blocks) == 0 {
+			return fmt.Errorf("no blocks mapped, run `z21 layout map` first")
+		}
+
+		if err := m.load(app, timeout); err != nil {
+			return err
+		}
+
+		if asJSON {
+			reports := []*layoutBlockReport{}
+			for _, b := range m.blocks {
+				reports = append(reports, b.report())
+			}
+			return printJSON(reports)
+		}
+		printLayoutBlocks(m)
+		return nil
+	},
+}
+
+// watch [--timeout | -t SECONDS] [--json]
+var layoutWatchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Watch the state changes of all blocks",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		asJSON, _ := cmd.Flags().GetBool("json")
+
+		app := GetAppContext(cmd)
+		if app == nil || app.Conn == nil {
+			return fmt.Errorf("Z21 connection not initialized")
+		}
+
+		c, err := loadCurrentContext()
+		if err != nil {
+			return err
+		}
+		m := newLayoutModel(c)
+		if len(m.blocks) == 0 {
+			return fmt.Errorf("no blocks mapped, run `z21 layout map` first")
+		}
+
+		flags := z21.CAN_DETECTOR_UPDATES | z21.FEEDBACK_UPDATES | z21.LOCONET_DETECTOR_UPDATES
+		if err := subscribe(app.Conn, flags); err != nil {
+			return err
+		}
+
+		if err := m.load(app, timeout); err != nil {
+			return err
+		}
+		if asJSON {
+			for _, b := range m.blocks {
+				if err := printJSONLine(b.report()); err != nil {
+					return err
+				}
+			}
+		} else {
+			printLayoutBlocks(m)
+			fmt.Println()
+		}
+
+		for {
+			var changed []*layoutBlock
+			select {
+			case ev := <-app.Conn.Events():
+				v, ok := ev.(*z21.CanDetector)
+				if !ok {
+					continue
+				}
+				changed = m.updateCanDetector(v)
+			case f := <-app.Frames:
+				changed = m.updateFrame(&f)
+			}
+
+			for _, b := range changed {
+				if asJSON {
+					if err := printJSONLine(b.report()); err != nil {
+						return err
+					}
+					continue
+				}
+				printLayoutBlockLine(b)
+			}
+		}
+	},
+}
+
+// ---------- model ----------
+
+// feedbackInput is a CAN detector port, R-Bus input or LocoNet detector
+// mapped to a block.
+type feedbackInput struct {
+	Source string
+	State  blockState
+	Locos  []uint16
+}
+
+// layoutBlock is a named block with the state merged from its inputs.
+type layoutBlock struct {
+	Name   string
+	Inputs []*feedbackInput
+	last   string
+}
+
+// layoutModel keeps the state of all mapped feedback inputs and their blocks.
+type layoutModel struct {
+	blocks  []*layoutBlock
+	inputs  map[string]*layoutBlock
+	devices map[uint16]*canDevice // CAN devices with the RailCom locos of their ports
+}
+
+func newLayoutModel(c *ContextInfo) *layoutModel {
+	m := &layoutModel{
+		inputs:  map[string]*layoutBlock{},
+		devices: map[uint16]*canDevice{},
+	}
+
+	byName := map[string]*layoutBlock{}
+	for _, kind := range []string{FEEDBACK_CAN, FEEDBACK_RBUS, FEEDBACK_LOCONET} {
+		for key, name := range *feedbackBlocks(c, kind) {
+			b, ok := byName[name]
+			if !ok {
+				b = &layoutBlock{Name: name}
+				byName[name] = b
+				m.blocks = append(m.blocks, b)
+			}
+			source := kind + ":" + key
+			b.Inputs = append(b.Inputs, &feedbackInput{Source: source})
+			m.inputs[source] = b
+		}
+	}
+
+	sort.Slice(m.blocks, func(i, j int) bool { return m.blocks[i].Name < m.blocks[j].Name })
+	for _, b := range m.blocks {
+		sort.Slice(b.Inputs, func(i, j int) bool { return b.Inputs[i].Source < b.Inputs[j].Source })
+		b.last = b.summary()
+	}
+	return m
+}
+
+// load requests the current state of all mapped inputs.
+func (m *layoutModel) load(app *AppContext, timeout time.Duration) error {
+	groups := map[uint8]bool{}
+	netids := map[uint16]bool{}
+	lnAddrs := []uint16{}
+	for source := range m.inputs {
+		kind, key, _ := strings.Cut(source, ":")
+		switch kind {
+		case FEEDBACK_CAN:
+			netid, _, _ := parseCanPort(key)
+			netids[netid] = true
+		case FEEDBACK_RBUS:
+			module, _, _ := parseRbusInput(key)
+			groups[uint8((module-1)/RBUS_GROUP_MODULES)] = true
+		case FEEDBACK_LOCONET:
+			addr, _ := strconv.ParseUint(key, 10, 16)
+			lnAddrs = append(lnAddrs, uint16(addr))
+		}
+	}
+
+	// R-Bus first, ReqFrame drops the frames it does not wait for
+	for g := range groups {
+		d, err := getRbusData(app, g)
+		if err != nil {
+			return fmt.Errorf("R-Bus group %d: %w", g, err)
+		}
+		m.updateRbus(d)
+	}
+
+	if len(lnAddrs) > 0 {
+		if err := subscribe(app.Conn, z21.LOCONET_DETECTOR_UPDATES); err != nil {
+			return err
+		}
+		// Uhlenbrock detectors only report their occupancy on the
+		// stationary interrogate request, as with loconet detector
+		for _, a := range lnAddrs {
+			for _, t := range []uint8{LN_DETECTOR_SIC, LN_DETECTOR_REPORT_QUERY} {
+				if _, err := Req(app.Conn, &loconetDetector{Type: t, Address: a}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if len(netids) > 0 {
+		// other CAN devices on the bus do not count for the early exit
+		devices, err := discoverCanDevices(app, timeout, 0, func(devices map[uint16]*canDevice) bool {
+			for netid := range netids {
+				if devices[netid] == nil {
+					return false
+				}
+			}
+			return true
+		}, nil)
+		if err != nil {
+			return err
+		}
+		for _, d := range devices {
+			m.devices[d.NetworkID] = d
+			for _, p := range d.Ports {
+				m.updateCanPort(d, p.Index)
+			}
+		}
+	}
+
+	// collect the LocoNet detector replies
+	if len(lnAddrs) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), DEFAULT_REQ_TIMEOUT)
+		defer cancel()
+		for {
+			select {
+			case <-ctx.Done():
+				m.commit()
+				return nil
+			case f := <-app.Frames:
+				m.updateFrame(&f)
+			}
+		}
+	}
+
+	m.commit()
+	return nil
+}
+
+// commit marks the current state of all blocks as reported.
+func (m *layoutModel) commit() {
+	for _, b := range m.blocks {
+		b.last = b.summary()
+	}
+}
+
+// update sets the state of a feedback input. It returns the block of the
+// input if the state or locos of the block changed since the last report.
+func (m *layoutModel) update(source string, state blockState, locos []uint16) *layoutBlock {
+	b, ok := m.inputs[source]
+	if !ok {
+		return nil
+	}
+	for _, in := range b.Inputs {
+		if in.Source == source {
+			in.State = state
+			in.Locos = locos
+		}
+	}
+
+	if s := b.summary(); s != b.last {
+		b.last = s
+		return b
+	}
+	return nil
+}
+
+// updateCanDetector updates the device of a CAN detector message, the devices
+// read by load keep their locos.
+func (m *layoutModel) updateCanDetector(v *z21.CanDetector) []*layoutBlock {
+	d, ok := m.devices[v.NetworkID]
+	if !ok {
+		d = newCanDevice(v)
+		m.devices[v.NetworkID] = d
+	}
+	d.update(v)
+	return m.updateCanPort(d, v.Port)
+}
+
+func (m *layoutModel) updateCanPort(d *canDevice, port uint8) []*layoutBlock {
+	for _, p := range d.Ports {
+		if p.Index != port {
+			continue
+		}
+		state := BLOCK_FREE
+		if isPortBusy(p.Status) {
+			state = BLOCK_BUSY
+		}
+		locos := []uint16{}
+		for _, l := range d.Locos[port] {
+			if l.Address != 0 {
+				locos = append(locos, l.Address)
+			}
+		}
+		source := FEEDBACK_CAN + ":" + formatCanPort(d.NetworkID, port)
+		if b := m.update(source, state, locos); b != nil {
+			return []*layoutBlock{b}
+		}
+	}
+	return nil
+}
+
+func (m *layoutModel) updateRbus(d *rbusData) []*layoutBlock {
+	changed := []*layoutBlock{}
+	for i, status := range d.Status {
+		for input := 0; input < RBUS_MODULE_INPUTS; input++ {
+			state := BLOCK_FREE
+			if status&(1<<input) != 0 {
+				state = BLOCK_BUSY
+			}
+			source := fmt.Sprintf("%s:%d:%d", FEEDBACK_RBUS, d.module(i), input+1)
+			if b := m.update(source, state, nil); b != nil {
+				changed = append(changed, b)
+			}
+		}
+	}
+	return changed
+}
+
+func (m *layoutModel) updateLocoNet(d *loconetDetector) []*layoutBlock {
+	source := fmt.Sprintf("%s:%d", FEEDBACK_LOCONET, d.Address)
+	b, ok := m.inputs[source]
+	if !ok {
+		return nil
+	}
+
+	var in *feedbackInput
+	for _, i := range b.Inputs {
+		if i.Source == source {
+			in = i
+		}
+	}
+
+	state, locos := in.State, in.Locos
+	switch d.Type {
+	case LN_DETECTOR_OCCUPANCY, LN_DETECTOR_LISSY_OCCUPANCY:
+		state = BLOCK_FREE
+		if len(d.Info) > 0 && d.Info[0] != 0 {
+			state = BLOCK_BUSY
+		} else {
+			locos = nil
+		}
+	case LN_DETECTOR_TRANSPONDER_ENTER, LN_DETECTOR_LISSY_ADDRESS:
+		if !containsAddress(locos, d.infoUint16()) {
+			locos = append(append([]uint16{}, locos...), d.infoUint16())
+		}
+	case LN_DETECTOR_TRANSPONDER_EXIT:
+		left := []uint16{}
+		for _, l := range locos {
+			if l != d.infoUint16() {
+				left = append(left, l)
+			}
+		}
+		locos = left
+	default:
+		return nil
+	}
+
+	if b := m.update(source, state, locos); b != nil {
+		return []*layoutBlock{b}
+	}
+	return nil
+}
+
+// updateFrame applies a raw R-Bus or LocoNet detector frame.
+func (m *layoutModel) updateFrame(f *z21.Frame) []*layoutBlock {
+	switch f.Header {
+	case z21.LAN_RMBUS_DATACHANGED:
+		d := &rbusData{}
+		if err := d.Unpack(f.Payload); err != nil {
+			return nil
+		}
+		return m.updateRbus(d)
+	case z21.LAN_LOCONET_DETECTOR:
+		d := &loconetDetector{}
+		if err := d.Unpack(f.Payload); err != nil {
+			return nil
+		}
+		return m.updateLocoNet(d)
+	}
+	return nil
+}
+
+// state is busy if any input is busy and free only if all inputs are free.
+func (b *layoutBlock) state() blockState {
+	state := BLOCK_FREE
+	for _, in := range b.Inputs {
+		switch in.State {
+		case BLOCK_BUSY:
+			return BLOCK_BUSY
+		case BLOCK_UNKNOWN:
+			state = BLOCK_UNKNOWN
+		}
+	}
+	return state
+}
+
+func (b *layoutBlock) locos() []uint16 {
+	locos := []uint16{}
+	for _, in := range b.Inputs {
+		for _, l := range in.Locos {
+			if !containsAddress(locos, l) {
+				locos = append(locos, l)
+			}
+		}
+	}
+	return locos
+}
+
+// busySources returns the inputs that report the block as busy.
+func (b *layoutBlock) busySources() []string {
+	sources := []string{}
+	for _, in := range b.Inputs {
+		if in.State == BLOCK_BUSY {
+			sources = append(sources, in.Source)
+		}
+	}
+	return sources
+}
+
+func (b *layoutBlock) summary() string {
+	return b.state().String() + " " + formatLocos(b.locos())
+}
+
+func (s blockState) String() string {
+	switch s {
+	case BLOCK_FREE:
+		return "free"
+	case BLOCK_BUSY:
+		return "busy"
+	default:
+		return "unknown"
+	}
+}
+
+type layoutBlockReport struct {
+	Name    string   `json:"name"`
+	State   string   `json:"state"`
+	Locos   []uint16 `json:"locos,omitempty"`
+	Sources []string `json:"sources"`
+	Busy    []string `json:"busy_sources,omitempty"`
+}
+
+func (b *layoutBlock) report() *layoutBlockReport {
+	r := &layoutBlockReport{
+		Name:  b.Name,
+		State: b.state().String(),
+		Locos: b.locos(),
+		Busy:  b.busySources(),
+	}
+	for _, in := range b.Inputs {
+		r.Sources = append(r.Sources, in.Source)
+	}
+	return r
+}
+
+// ---------- helpers ----------
+
+// parseFeedbackSource parses a feedback input and returns its kind and the
+// normalized key used in the context store.
+func parseFeedbackSource(s string) (string, string, error) {
+	kind, key, ok := strings.Cut(s, ":")
+	if !ok {
+		return "", "", fmt.Errorf("invalid feedback input %q, expected can:NETID:PORT, rbus:MODULE:INPUT or ln:ADDR", s)
+	}
+
+	switch strings.ToLower(kind) {
+	case FEEDBACK_CAN:
+		netid, port, err := parseCanPort(key)
+		if err != nil {
+			return "", "", err
+		}
+		return FEEDBACK_CAN, formatCanPort(netid, port), nil
+	case FEEDBACK_RBUS:
+		module, input, err := parseRbusInput(key)
+		if err != nil {
+			return "", "", err
+		}
+		return FEEDBACK_RBUS, fmt.Sprintf("%d:%d", module, input), nil
+	case FEEDBACK_LOCONET:
+		addr, err := strconv.ParseUint(key, 0, 16)
+		if err != nil || addr == 0 {
+			return "", "", fmt.Errorf("invalid LocoNet detector address %q", key)
+		}
+		return FEEDBACK_LOCONET, fmt.Sprintf("%d", addr), nil
+	default:
+		return "", "", fmt.Errorf("invalid feedback input %q, expected can:NETID:PORT, rbus:MODULE:INPUT or ln:ADDR", s)
+	}
+}
+
+// parseRbusInput parses MODULE:INPUT, both counted from 1 as shown by
+// `rbus status`.
+func parseRbusInput(s string) (int, int, error) {
+	m, i, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid R-Bus input %q, expected MODULE:INPUT", s)
+	}
+	module, err := strconv.Atoi(m)
+	if err != nil || module < 1 || module > int(RBUS_GROUPS)*RBUS_GROUP_MODULES {
+		return 0, 0, fmt.Errorf("invalid R-Bus module %q (1-%d)", m, int(RBUS_GROUPS)*RBUS_GROUP_MODULES)
+	}
+	input, err := strconv.Atoi(i)
+	if err != nil || input < 1 || input > RBUS_MODULE_INPUTS {
+		return 0, 0, fmt.Errorf("invalid R-Bus input %q (1-%d)", i, RBUS_MODULE_INPUTS)
+	}
+	return module, input, nil
+}
+
+// feedbackBlocks returns the block names of a kind of feedback input.
+func feedbackBlocks(c *ContextInfo, kind string) *map[string]string {
+	switch kind {
+	case FEEDBACK_RBUS:
+		return &c.RbusBlocks
+	case FEEDBACK_LOCONET:
+		return &c.LocoNetBlocks
+	default:
+		return &c.CanBlocks
+	}
+}
+
+func formatLocos(locos []uint16) string {
+	out := []string{}
+	for _, l := range locos {
+		out = append(out, fmt.Sprintf("%d", l))
+	}
+	return strings.Join(out, ",")
+}
+
+func printLayoutBlocks(m *layoutModel) {
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.Style().Options.DrawBorder = false
+	t.Style().Options.SeparateColumns = false
+	t.AppendHeader(table.Row{"Block", "State", "Loco(s)", "Feedback"})
+	for _, b := range m.blocks {
+		sources := []string{}
+		for _, in := range b.Inputs {
+			sources = append(sources, fmt.Sprintf("%s (%s)", in.Source, in.State))
+		}
+		t.AppendRow(
+			table.Row{
+				b.Name,
+				b.state().String(),
+				formatLocos(b.locos()),
+				strings.Join(sources, ", "),
+			},
+		)
+	}
+	t.Render()
+}
+
+func printLayoutBlockLine(b *layoutBlock) {
+	ts := time.Now().Format("15:04:05.000")
+	line := fmt.Sprintf("[BLK] %s %-20s %-7s", ts, b.Name, b.state().String())
+	if locos := formatLocos(b.locos()); locos != "" {
+		line += " Loco(s): " + locos
+	}
+	if sources := b.busySources(); len(sources) > 0 {
+		line += " (" + strings.Join(sources, ", ") + ")"
+	}
+	fmt.Println(line)
+}
+
+// ---------- init ----------
+
+func init() {
+	// mapping only changes the context store, no connection needed
+	for _, c := range []*cobra.Command{layoutMapCmd, layoutUnmapCmd} {
+		c.PersistentPreRunE = func(cmd *cobra.Command, args []string) error { return nil }
+		c.PersistentPostRun = func(cmd *cobra.Command, args []string) {}
+	}
+	layoutCmd.AddCommand(
+		layoutMapCmd,
+		layoutUnmapCmd,
+		layoutBlocksCmd,
+		layoutWatchCmd,
+	)
+
+	for _, c := range []*cobra.Command{layoutBlocksCmd, layoutWatchCmd} {
+		c.Flags().DurationP("timeout", "t", DEFAULT_SCAN_TIMEOUT, "timeout in seconds")
+		c.Flags().Bool("json", false, "print the blocks as JSON")
+	}
+}
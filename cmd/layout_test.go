@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/trains-io/z21.go"
+)
+
+func TestLayoutModelKeepsCanLocos(t *testing.T) {
+	m := newLayoutModel(&ContextInfo{CanBlocks: map[string]string{"0xDB04:1": "Yard"}})
+
+	// the state read by load: port 1 busy with loco 3
+	for _, v := range []*z21.CanDetector{
+		{NetworkID: 0xdb04, Port: 0, Type: z21.CANMessageTypeStatus, Value1: z21.BUSY},
+		{NetworkID: 0xdb04, Port: 0, Type: CAN_MESSAGE_TYPE_RAILCOM_FIRST, Value1: 0x0003},
+	} {
+		m.updateCanDetector(v)
+	}
+	m.commit()
+
+	// a repeated status report is no block change and keeps the loco
+	changed := m.updateCanDetector(&z21.CanDetector{NetworkID: 0xdb04, Port: 0, Type: z21.CANMessageTypeStatus, Value1: z21.BUSY})
+	if len(changed) != 0 {
+		t.Errorf("repeated status reported %d changed block(s)", len(changed))
+	}
+	if locos := m.blocks[0].locos(); !slices.Equal(locos, []uint16{3}) {
+		t.Errorf("locos = %v, want [3]", locos)
+	}
+
+	changed = m.updateCanDetector(&z21.CanDetector{NetworkID: 0xdb04, Port: 0, Type: z21.CANMessageTypeStatus, Value1: z21.FREE})
+	if len(changed) != 1 || changed[0].state() != BLOCK_FREE || len(changed[0].locos()) != 0 {
+		t.Errorf("free port not reported as free block without locos")
+	}
+}
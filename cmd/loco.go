@@ -0,0 +1,243 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/trains-io/z21.go"
+)
+
+const (
+	LOCO_MAX_SPEED uint8 = 126 // 128 speed steps
+	LOCO_FORWARD   uint8 = 0x80
+	LOCO_BUSY      uint8 = 0x08
+	LOCO_LIGHT     uint8 = 0x10
+)
+
+var locoCmd = &cobra.Command{
+	Use:   "loco",
+	Short: "Drive locomotives",
+}
+
+// ---------- subcommands ----------
+
+// drive ADDR --speed N [--reverse]
+var locoDriveCmd = &cobra.Command{
+	Use:   "drive ADDR",
+	Short: "Set the speed and direction of a loco (128 speed steps)",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		speed, _ := cmd.Flags().GetUint8("speed")
+		reverse, _ := cmd.Flags().GetBool("reverse")
+
+		addr, err := parseLocoAddress(args[0])
+		if err != nil {
+			return err
+		}
+
+		app := GetAppContext(cmd)
+		if app == nil || app.Conn == nil {
+			return fmt.Errorf("Z21 connection not initialized")
+		}
+
+		if err := driveLoco(app, addr, speed, !reverse); err != nil {
+			return err
+		}
+
+		dir := "forward"
+		if reverse {
+			dir = "reverse"
+		}
+		fmt.Printf("Loco %d: speed %d %s\n", addr, speed, dir)
+		return nil
+	},
+}
+
+// stop ADDR
+var locoStopCmd = &cobra.Command{
+	Use:   "stop ADDR",
+	Short: "Emergency stop a loco",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		addr, err := parseLocoAddress(args[0])
+		if err != nil {
+			return err
+		}
+
+		app := GetAppContext(cmd)
+		if app == nil || app.Conn == nil {
+			return fmt.Errorf("Z21 connection not initialized")
+		}
+
+		if _, err := Req(app.Conn, &locoEStop{Address: addr}); err != nil {
+			return err
+		}
+		fmt.Printf("Loco %d stopped.\n", addr)
+		return nil
+	},
+}
+
+func parseLocoAddress(s string) (uint16, error) {
+	val, err := strconv.ParseUint(s, 0, 16)
+	if err != nil || val == 0 || val > 9999 {
+		return 0, fmt.Errorf("invalid loco address %q (1-9999)", s)
+	}
+	return uint16(val), nil
+}
+
+// driveLoco sets the speed (0-126) and direction of a loco.
+func driveLoco(app *AppContext, addr uint16, speed uint8, forward bool) error {
+	if speed > LOCO_MAX_SPEED {
+		return fmt.Errorf("invalid speed %d (0-%d)", speed, LOCO_MAX_SPEED)
+	}
+	_, err := Req(app.Conn, &locoDrive{Address: addr, Speed: speed, Forward: forward})
+	return err
+}
+
+// formatLocoInfo formats the state of a loco, e.g. "speed 40 forward F0 F3".
+func formatLocoInfo(l *locoInfo) string {
+	dir := "forward"
+	if !l.Forward {
+		dir = "reverse"
+	}
+	s := fmt.Sprintf("speed %d/%d %s", l.Speed, l.SpeedSteps, dir)
+	if l.EmergencyStop {
+		s = fmt.Sprintf("emergency stop %s", dir)
+	}
+	for _, fn := range l.functions() {
+		s += fmt.Sprintf(" F%d", fn)
+	}
+	return s
+}
+
+// ---------- messages ----------
+
+// LAN_X_SET_LOCO_DRIVE
+type locoDrive struct {
+	Address uint16
+	Speed   uint8
+	Forward bool
+}
+
+func (m *locoDrive) Pack() ([]byte, error) {
+	msb, lsb := packLocoAddress(m.Address)
+
+	// speed step 1 is the emergency stop
+	speed := m.Speed
+	if speed > 0 {
+		speed++
+	}
+	if m.Forward {
+		speed |= LOCO_FORWARD
+	}
+
+	b := []byte{z21.LAN_X_E4, z21.LAN_X_SET_LOCO_DRIVE_S3, msb, lsb, speed}
+	return append(b, xor(b)), nil
+}
+
+func (m *locoDrive) Unpack(data []byte) error {
+	return nil
+}
+
+func (m *locoDrive) EncapType() uint16 {
+	return z21.LAN_X
+}
+
+func (m *locoDrive) Key() (string, bool) {
+	return "", false
+}
+
+// LAN_X_SET_LOCO_E_STOP
+type locoEStop struct {
+	Address uint16
+}
+
+func (m *locoEStop) Pack() ([]byte, error) {
+	msb, lsb := packLocoAddress(m.Address)
+	b := []byte{z21.LAN_X_SET_LOCO_E_STOP, msb, lsb}
+	return append(b, xor(b)), nil
+}
+
+func (m *locoEStop) Unpack(data []byte) error {
+	return nil
+}
+
+func (m *locoEStop) EncapType() uint16 {
+	return z21.LAN_X
+}
+
+func (m *locoEStop) Key() (string, bool) {
+	return "", false
+}
+
+// LAN_X_LOCO_INFO, the LocoInfo of z21.go does not decode the loco state.
+type locoInfo struct {
+	Address       uint16
+	Busy          bool
+	SpeedSteps    int
+	Speed         uint8
+	EmergencyStop bool
+	Forward       bool
+	Functions     uint32 // bit n is set if Fn is on
+}
+
+func (m *locoInfo) Unpack(data []byte) error {
+	// X-Header, address, speed steps, speed, F0-F4 and the checksum at least
+	if len(data) < 7 || data[0] != z21.LAN_X_LOCO_INFO {
+		return fmt.Errorf("invalid loco info")
+	}
+	m.Address = uint16(data[1]&0x3f)<<8 | uint16(data[2])
+	m.Busy = data[3]&LOCO_BUSY != 0
+	m.Forward = data[4]&LOCO_FORWARD != 0
+
+	// step 0 is stop and step 1 the emergency stop (steps 0-3 at 28 steps)
+	var step, stop uint8
+	switch data[3] & 0x07 {
+	case 0:
+		m.SpeedSteps = 14
+		step, stop = data[4]&0x0f, 1
+	case 2:
+		m.SpeedSteps = 28
+		step, stop = (data[4]&0x0f)<<1|(data[4]>>4)&0x01, 3
+	default:
+		m.SpeedSteps = 128
+		step, stop = data[4]&0x7f, 1
+	}
+	m.EmergencyStop = step == stop || (stop == 3 && step == 2)
+	if step > stop {
+		m.Speed = step - stop
+	}
+
+	m.Functions = uint32(data[5]&0x0f)<<1 | uint32(data[5]&LOCO_LIGHT)>>4
+	for i, b := range data[6 : len(data)-1] {
+		if i > 2 {
+			break
+		}
+		m.Functions |= uint32(b) << (5 + 8*i)
+	}
+	return nil
+}
+
+// functions returns the numbers of the functions that are on.
+func (m *locoInfo) functions() []int {
+	fns := []int{}
+	for fn := 0; fn <= 28; fn++ {
+		if m.Functions&(1<<fn) != 0 {
+			fns = append(fns, fn)
+		}
+	}
+	return fns
+}
+
+// ---------- init ----------
+
+func init() {
+	locoCmd.AddCommand(
+		locoDriveCmd,
+		locoStopCmd,
+	)
+
+	locoDriveCmd.Flags().Uint8("speed", 0, fmt.Sprintf("speed (0-%d)", LOCO_MAX_SPEED))
+	locoDriveCmd.Flags().Bool("reverse", false, "drive in reverse direction")
+}
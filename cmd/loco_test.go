@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/trains-io/z21.go"
+)
+
+// locoInfoFrame returns the LAN_X_LOCO_INFO payload of db with its checksum.
+func locoInfoFrame(db ...byte) []byte {
+	data := append([]byte{z21.LAN_X_LOCO_INFO}, db...)
+	return append(data, xor(data))
+}
+
+func TestLocoInfoUnpack(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want locoInfo
+		fns  []int
+	}{
+		{
+			name: "14 steps",
+			data: locoInfoFrame(0x00, 0x03, 0x00, 0x85, 0x10, 0x00, 0x00, 0x00),
+			want: locoInfo{Address: 3, SpeedSteps: 14, Speed: 4, Forward: true, Functions: 1},
+			fns:  []int{0},
+		},
+		{
+			name: "14 steps emergency stop",
+			data: locoInfoFrame(0x00, 0x03, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00),
+			want: locoInfo{Address: 3, SpeedSteps: 14, EmergencyStop: true},
+			fns:  []int{},
+		},
+		{
+			name: "28 steps",
+			data: locoInfoFrame(0x00, 0x03, 0x02, 0x93, 0x00, 0x00, 0x00, 0x00),
+			want: locoInfo{Address: 3, SpeedSteps: 28, Speed: 4, Forward: true},
+			fns:  []int{},
+		},
+		{
+			name: "28 steps stop",
+			data: locoInfoFrame(0x00, 0x03, 0x02, 0x10, 0x00, 0x00, 0x00, 0x00),
+			want: locoInfo{Address: 3, SpeedSteps: 28},
+			fns:  []int{},
+		},
+		{
+			name: "28 steps emergency stop",
+			data: locoInfoFrame(0x00, 0x03, 0x02, 0x01, 0x00, 0x00, 0x00, 0x00),
+			want: locoInfo{Address: 3, SpeedSteps: 28, EmergencyStop: true},
+			fns:  []int{},
+		},
+		{
+			name: "28 steps emergency stop intermediate step",
+			data: locoInfoFrame(0x00, 0x03, 0x02, 0x11, 0x00, 0x00, 0x00, 0x00),
+			want: locoInfo{Address: 3, SpeedSteps: 28, EmergencyStop: true},
+			fns:  []int{},
+		},
+		{
+			name: "128 steps busy long address",
+			data: locoInfoFrame(0xc3, 0xe8, 0x0c, 0xe5, 0x00, 0x00, 0x00, 0x00),
+			want: locoInfo{Address: 1000, Busy: true, SpeedSteps: 128, Speed: 100, Forward: true},
+			fns:  []int{},
+		},
+		{
+			name: "128 steps emergency stop",
+			data: locoInfoFrame(0x00, 0x03, 0x04, 0x81, 0x00, 0x00, 0x00, 0x00),
+			want: locoInfo{Address: 3, SpeedSteps: 128, Forward: true, EmergencyStop: true},
+			fns:  []int{},
+		},
+		{
+			name: "F1-F4",
+			data: locoInfoFrame(0x00, 0x03, 0x04, 0x00, 0x0f, 0x00, 0x00, 0x00),
+			want: locoInfo{Address: 3, SpeedSteps: 128, Functions: 0x1e},
+			fns:  []int{1, 2, 3, 4},
+		},
+		{
+			name: "F5-F28",
+			data: locoInfoFrame(0x00, 0x03, 0x04, 0x00, 0x00, 0x81, 0x01, 0x80),
+			want: locoInfo{Address: 3, SpeedSteps: 128, Functions: 1<<5 | 1<<12 | 1<<13 | 1<<28},
+			fns:  []int{5, 12, 13, 28},
+		},
+		{
+			name: "F29 and above are ignored",
+			data: locoInfoFrame(0x00, 0x03, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff),
+			want: locoInfo{Address: 3, SpeedSteps: 128},
+			fns:  []int{},
+		},
+		{
+			name: "F0-F4 only",
+			data: locoInfoFrame(0x00, 0x03, 0x04, 0x00, 0x11),
+			want: locoInfo{Address: 3, SpeedSteps: 128, Functions: 0x03},
+			fns:  []int{0, 1},
+		},
+	}
+
+	for _, tt := range tests {
+		var got locoInfo
+		if err := got.Unpack(tt.data); err != nil {
+			t.Errorf("%s: Unpack failed: %s", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: Unpack = %+v, want %+v", tt.name, got, tt.want)
+		}
+		if fns := got.functions(); !slices.Equal(fns, tt.fns) {
+			t.Errorf("%s: functions = %v, want %v", tt.name, fns, tt.fns)
+		}
+	}
+}
+
+func TestLocoInfoUnpackInvalid(t *testing.T) {
+	for _, data := range [][]byte{
+		nil,
+		locoInfoFrame(0x00, 0x03, 0x04, 0x00),
+		{z21.LAN_X_61, 0x00, 0x03, 0x04, 0x00, 0x00, 0x00},
+	} {
+		var l locoInfo
+		if err := l.Unpack(data); err == nil {
+			t.Errorf("Unpack(% x) succeeded, want error", data)
+		}
+	}
+}
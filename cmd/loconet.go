@@ -0,0 +1,561 @@
+package cmd
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+	"github.com/trains-io/z21.go"
+)
+
+const (
+	OPC_BUSY        uint8 = 0x81
+	OPC_GPOFF       uint8 = 0x82
+	OPC_GPON        uint8 = 0x83
+	OPC_IDLE        uint8 = 0x85
+	OPC_LOCO_SPD    uint8 = 0xA0
+	OPC_LOCO_DIRF   uint8 = 0xA1
+	OPC_LOCO_SND    uint8 = 0xA2
+	OPC_SW_REQ      uint8 = 0xB0
+	OPC_SW_REP      uint8 = 0xB1
+	OPC_INPUT_REP   uint8 = 0xB2
+	OPC_LONG_ACK    uint8 = 0xB4
+	OPC_SLOT_STAT1  uint8 = 0xB5
+	OPC_MOVE_SLOTS  uint8 = 0xBA
+	OPC_RQ_SL_DATA  uint8 = 0xBB
+	OPC_SW_STATE    uint8 = 0xBC
+	OPC_SW_ACK      uint8 = 0xBD
+	OPC_LOCO_ADR    uint8 = 0xBF
+	OPC_MULTI_SENSE uint8 = 0xD0
+	OPC_SL_RD_DATA  uint8 = 0xE7
+	OPC_WR_SL_DATA  uint8 = 0xEF
+)
+
+const (
+	// LAN_LOCONET_DETECTOR requests
+	LN_DETECTOR_SIC          uint8 = 0x80 // Uhlenbrock stationary interrogate
+	LN_DETECTOR_REPORT_QUERY uint8 = 0x81
+	LN_DETECTOR_LISSY_QUERY  uint8 = 0x82
+
+	// LAN_LOCONET_DETECTOR replies
+	LN_DETECTOR_OCCUPANCY         uint8 = 0x01
+	LN_DETECTOR_TRANSPONDER_ENTER uint8 = 0x02
+	LN_DETECTOR_TRANSPONDER_EXIT  uint8 = 0x03
+	LN_DETECTOR_LISSY_ADDRESS     uint8 = 0x10
+	LN_DETECTOR_LISSY_OCCUPANCY   uint8 = 0x11
+	LN_DETECTOR_LISSY_SPEED       uint8 = 0x12
+)
+
+var opcodes = map[uint8]string{
+	OPC_BUSY:        "OPC_BUSY",
+	OPC_GPOFF:       "OPC_GPOFF",
+	OPC_GPON:        "OPC_GPON",
+	OPC_IDLE:        "OPC_IDLE",
+	OPC_LOCO_SPD:    "OPC_LOCO_SPD",
+	OPC_LOCO_DIRF:   "OPC_LOCO_DIRF",
+	OPC_LOCO_SND:    "OPC_LOCO_SND",
+	OPC_SW_REQ:      "OPC_SW_REQ",
+	OPC_SW_REP:      "OPC_SW_REP",
+	OPC_INPUT_REP:   "OPC_INPUT_REP",
+	OPC_LONG_ACK:    "OPC_LONG_ACK",
+	OPC_SLOT_STAT1:  "OPC_SLOT_STAT1",
+	OPC_MOVE_SLOTS:  "OPC_MOVE_SLOTS",
+	OPC_RQ_SL_DATA:  "OPC_RQ_SL_DATA",
+	OPC_SW_STATE:    "OPC_SW_STATE",
+	OPC_SW_ACK:      "OPC_SW_ACK",
+	OPC_LOCO_ADR:    "OPC_LOCO_ADR",
+	OPC_MULTI_SENSE: "OPC_MULTI_SENSE",
+	OPC_SL_RD_DATA:  "OPC_SL_RD_DATA",
+	OPC_WR_SL_DATA:  "OPC_WR_SL_DATA",
+}
+
+var loconetCmd = &cobra.Command{
+	Use:     "loconet",
+	Aliases: []string{"ln"},
+	Short:   "Send and inspect LocoNet messages",
+}
+
+// ---------- subcommands ----------
+
+// send HEX
+var loconetSendCmd = &cobra.Command{
+	Use:   "send HEX",
+	Short: "Send a LocoNet message, the checksum is appended",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		msg, err := hex.DecodeString(strings.Join(args, ""))
+		if err != nil {
+			return fmt.Errorf("invalid LocoNet message: %w", err)
+		}
+		if len(msg) == 0 || msg[0]&0x80 == 0 {
+			return fmt.Errorf("invalid LocoNet message: missing opcode")
+		}
+		msg = append(msg, lnChecksum(msg))
+		if n := lnMessageLength(msg); n != len(msg) {
+			return fmt.Errorf("invalid LocoNet message: %s expects %d bytes including checksum, got %d", formatOpcode(msg[0]), n, len(msg))
+		}
+
+		app := GetAppContext(cmd)
+		if app == nil || app.Conn == nil {
+			return fmt.Errorf("Z21 connection not initialized")
+		}
+
+		if _, err := Req(app.Conn, &loconetFromLan{Message: msg}); err != nil {
+			return err
+		}
+
+		fmt.Printf("Sent %s: %s\n", formatLocoNetHex(msg), formatLocoNet(msg))
+		return nil
+	},
+}
+
+// dispatch ADDR
+var loconetDispatchCmd = &cobra.Command{
+	Use:   "dispatch ADDR",
+	Short: "Prepare a loco address for LocoNet dispatch",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		val, err := strconv.ParseUint(args[0], 0, 16)
+		if err != nil {
+			return err
+		}
+		addr := uint16(val)
+
+		app := GetAppContext(cmd)
+		if app == nil || app.Conn == nil {
+			return fmt.Errorf("Z21 connection not initialized")
+		}
+
+		f, err := ReqFrame(app, &loconetDispatchAddr{Address: addr}, DEFAULT_REQ_TIMEOUT, func(f *z21.Frame) bool {
+			return f.Header == z21.LAN_LOCONET_DISPATCH_ADDR &&
+				len(f.Payload) >= 3 &&
+				binary.LittleEndian.Uint16(f.Payload) == addr
+		})
+		if err != nil {
+			return err
+		}
+
+		slot := f.Payload[2]
+		if slot == 0 {
+			return fmt.Errorf("failed to dispatch loco %d", addr)
+		}
+		fmt.Printf("Loco %d dispatched in slot %d, acquire it with DISPATCH GET on the throttle.\n", addr, slot)
+		return nil
+	},
+}
+
+// detector ADDR [--timeout | -t SECONDS]
+var loconetDetectorCmd = &cobra.Command{
+	Use:     "detector ADDR",
+	Aliases: []string{"det"},
+	Short:   "Show LocoNet occupancy detector information",
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		val, err := strconv.ParseUint(args[0], 0, 16)
+		if err != nil {
+			return err
+		}
+		addr := uint16(val)
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+
+		app := GetAppContext(cmd)
+		if app == nil || app.Conn == nil {
+			return fmt.Errorf("Z21 connection not initialized")
+		}
+
+		if err := subscribe(app.Conn, z21.LOCONET_DETECTOR_UPDATES); err != nil {
+			return err
+		}
+
+		// Uhlenbrock 4-fold detectors report their occupancy (type 0x01)
+		// only on the stationary interrogate request
+		for _, t := range []uint8{LN_DETECTOR_SIC, LN_DETECTOR_REPORT_QUERY, LN_DETECTOR_LISSY_QUERY} {
+			if _, err := Req(app.Conn, &loconetDetector{Type: t, Address: addr}); err != nil {
+				return err
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		reports := []*loconetDetector{}
+
+		for {
+			select {
+			case <-ctx.Done():
+				printLocoNetDetector(addr, reports)
+				return nil
+			case f := <-app.Frames:
+				if f.Header != z21.LAN_LOCONET_DETECTOR {
+					continue
+				}
+				d := &loconetDetector{}
+				if err := d.Unpack(f.Payload); err != nil || d.Address != addr {
+					continue
+				}
+				reports = mergeDetectorReport(reports, d)
+			}
+		}
+	},
+}
+
+// mergeDetectorReport replaces an earlier report of the same kind (and loco,
+// for transponder reports) with d or appends d.
+func mergeDetectorReport(reports []*loconetDetector, d *loconetDetector) []*loconetDetector {
+	for i, r := range reports {
+		if formatDetectorType(r.Type) != formatDetectorType(d.Type) {
+			continue
+		}
+		switch d.Type {
+		case LN_DETECTOR_TRANSPONDER_ENTER, LN_DETECTOR_TRANSPONDER_EXIT, LN_DETECTOR_LISSY_ADDRESS:
+			if r.infoUint16() != d.infoUint16() {
+				continue
+			}
+		}
+		reports[i] = d
+		return reports
+	}
+	return append(reports, d)
+}
+
+func printLocoNetDetector(addr uint16, reports []*loconetDetector) {
+	if len(reports) == 0 {
+		fmt.Printf("Detector not found\n")
+		return
+	}
+
+	fmt.Printf("Detector: %d\n", addr)
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.Style().Options.DrawBorder = false
+	t.Style().Options.SeparateColumns = false
+	t.AppendHeader(table.Row{"Report", "Status"})
+	for _, d := range reports {
+		t.AppendRow(
+			table.Row{
+				formatDetectorType(d.Type),
+				formatDetectorInfo(d),
+			},
+		)
+	}
+	t.Render()
+}
+
+func formatDetectorType(typ uint8) string {
+	switch typ {
+	case LN_DETECTOR_OCCUPANCY:
+		return "occupancy"
+	case LN_DETECTOR_TRANSPONDER_ENTER, LN_DETECTOR_TRANSPONDER_EXIT:
+		return "transponder"
+	case LN_DETECTOR_LISSY_ADDRESS:
+		return "LISSY address"
+	case LN_DETECTOR_LISSY_OCCUPANCY:
+		return "LISSY occupancy"
+	case LN_DETECTOR_LISSY_SPEED:
+		return "LISSY speed"
+	default:
+		return fmt.Sprintf("0x%02x", typ)
+	}
+}
+
+func formatDetectorInfo(d *loconetDetector) string {
+	switch d.Type {
+	case LN_DETECTOR_OCCUPANCY, LN_DETECTOR_LISSY_OCCUPANCY:
+		if len(d.Info) > 0 && d.Info[0] != 0 {
+			return "busy"
+		}
+		return "free"
+	case LN_DETECTOR_TRANSPONDER_ENTER:
+		return fmt.Sprintf("loco %d entered", d.infoUint16())
+	case LN_DETECTOR_TRANSPONDER_EXIT:
+		return fmt.Sprintf("loco %d left", d.infoUint16())
+	case LN_DETECTOR_LISSY_ADDRESS:
+		if len(d.Info) < 3 {
+			return fmt.Sprintf("loco %d", d.infoUint16())
+		}
+		dir := "fwd"
+		if d.Info[2]&0x20 != 0 {
+			dir = "rev"
+		}
+		return fmt.Sprintf("loco %d (class %d, %s)", d.infoUint16(), d.Info[2]&0x0f, dir)
+	case LN_DETECTOR_LISSY_SPEED:
+		return fmt.Sprintf("%d", d.infoUint16())
+	default:
+		return strings.ToUpper(hex.EncodeToString(d.Info))
+	}
+}
+
+func printLocoNetDetectorLine(d *loconetDetector) {
+	fmt.Printf("[LND] Report: %-5d %-15s %s\n", d.Address, formatDetectorType(d.Type), formatDetectorInfo(d))
+}
+
+// lnChecksum calculates the LocoNet checksum byte of msg.
+func lnChecksum(msg []byte) byte {
+	return 0xff ^ xor(msg)
+}
+
+// lnMessageLength returns the length of a LocoNet message including the
+// checksum, as encoded in its opcode.
+func lnMessageLength(msg []byte) int {
+	switch msg[0] & 0x60 {
+	case 0x00:
+		return 2
+	case 0x20:
+		return 4
+	case 0x40:
+		return 6
+	default:
+		if len(msg) < 2 {
+			return 0
+		}
+		return int(msg[1])
+	}
+}
+
+func formatOpcode(opc uint8) string {
+	if name, ok := opcodes[opc]; ok {
+		return name
+	}
+	return fmt.Sprintf("OPC_0x%02X", opc)
+}
+
+func formatLocoNetHex(msg []byte) string {
+	return strings.ToUpper(hex.EncodeToString(msg))
+}
+
+// formatLocoNet decodes the common LocoNet opcodes into a readable line.
+func formatLocoNet(msg []byte) string {
+	if len(msg) < 2 {
+		return "truncated message"
+	}
+	if xor(msg) != 0xff {
+		return fmt.Sprintf("%s (bad checksum)", formatOpcode(msg[0]))
+	}
+
+	opc := msg[0]
+	desc := ""
+	switch {
+	case opc == OPC_GPON:
+		desc = "global power on"
+	case opc == OPC_GPOFF:
+		desc = "global power off"
+	case opc == OPC_IDLE:
+		desc = "emergency stop"
+	case opc == OPC_BUSY:
+		desc = "master busy"
+	case opc == OPC_LOCO_SPD && len(msg) >= 4:
+		desc = fmt.Sprintf("slot %d speed %s", msg[1], formatLocoNetSpeed(msg[2]))
+	case opc == OPC_LOCO_DIRF && len(msg) >= 4:
+		dir := "fwd"
+		if msg[2]&0x20 != 0 {
+			dir = "rev"
+		}
+		// F0 is bit 4, F1-F4 are bits 0-3
+		fns := msg[2]&0x0f<<1 | msg[2]&0x10>>4
+		desc = fmt.Sprintf("slot %d dir %s functions %s", msg[1], dir, formatFunctions(0, fns, 5))
+	case opc == OPC_LOCO_SND && len(msg) >= 4:
+		desc = fmt.Sprintf("slot %d functions %s", msg[1], formatFunctions(5, msg[2]&0x0f, 4))
+	case (opc == OPC_SW_REQ || opc == OPC_SW_ACK) && len(msg) >= 4:
+		pos := "thrown"
+		if msg[2]&0x20 != 0 {
+			pos = "closed"
+		}
+		state := "off"
+		if msg[2]&0x10 != 0 {
+			state = "on"
+		}
+		desc = fmt.Sprintf("switch %d %s (output %s)", lnSwitchAddress(msg), pos, state)
+	case opc == OPC_SW_STATE && len(msg) >= 4:
+		desc = fmt.Sprintf("switch %d state request", lnSwitchAddress(msg))
+	case opc == OPC_SW_REP && len(msg) >= 4:
+		if msg[2]&0x40 != 0 {
+			desc = fmt.Sprintf("switch %d input %s", lnSwitchAddress(msg), formatLevel(msg[2]&0x10 != 0))
+		} else {
+			desc = fmt.Sprintf("switch %d closed %s thrown %s", lnSwitchAddress(msg),
+				formatLevel(msg[2]&0x20 != 0), formatLevel(msg[2]&0x10 != 0))
+		}
+	case opc == OPC_INPUT_REP && len(msg) >= 4:
+		state := "free"
+		if msg[2]&0x10 != 0 {
+			state = "busy"
+		}
+		desc = fmt.Sprintf("sensor %d %s", lnSensorAddress(msg), state)
+	case opc == OPC_LONG_ACK && len(msg) >= 4:
+		desc = fmt.Sprintf("ack for %s: 0x%02X", formatOpcode(msg[1]|0x80), msg[2])
+	case opc == OPC_LOCO_ADR && len(msg) >= 4:
+		desc = fmt.Sprintf("request slot for loco %d", uint16(msg[1])<<7|uint16(msg[2]))
+	case opc == OPC_RQ_SL_DATA && len(msg) >= 4:
+		desc = fmt.Sprintf("request data of slot %d", msg[1])
+	case opc == OPC_MOVE_SLOTS && len(msg) >= 4:
+		desc = fmt.Sprintf("move slot %d to %d", msg[1], msg[2])
+	case (opc == OPC_SL_RD_DATA || opc == OPC_WR_SL_DATA) && len(msg) >= 14:
+		addr := uint16(msg[9])<<7 | uint16(msg[4])
+		desc = fmt.Sprintf("slot %d loco %d speed %s", msg[2], addr, formatLocoNetSpeed(msg[5]))
+	default:
+		desc = formatLocoNetHex(msg)
+	}
+	return fmt.Sprintf("%-15s %s", formatOpcode(opc), desc)
+}
+
+func formatLocoNetSpeed(spd uint8) string {
+	switch spd {
+	case 0:
+		return "stop"
+	case 1:
+		return "e-stop"
+	default:
+		return fmt.Sprintf("%d", spd-1)
+	}
+}
+
+// formatFunctions lists the active functions of a function group, where bit
+// 0 of bits is function first.
+func formatFunctions(first int, bits uint8, n int) string {
+	active := []string{}
+	for i := 0; i < n; i++ {
+		if bits&(1<<i) != 0 {
+			active = append(active, fmt.Sprintf("F%d", first+i))
+		}
+	}
+	if len(active) == 0 {
+		return "none"
+	}
+	return strings.Join(active, " ")
+}
+
+func formatLevel(hi bool) string {
+	if hi {
+		return "hi"
+	}
+	return "lo"
+}
+
+// lnSwitchAddress returns the 1-based switch address of an OPC_SW_* message.
+func lnSwitchAddress(msg []byte) uint16 {
+	return (uint16(msg[2]&0x0f)<<7 | uint16(msg[1])) + 1
+}
+
+// lnSensorAddress returns the 1-based sensor address of an OPC_INPUT_REP.
+func lnSensorAddress(msg []byte) uint16 {
+	return (uint16(msg[2]&0x0f)<<8 | uint16(msg[1])<<1 | uint16(msg[2]>>5)&0x01) + 1
+}
+
+// printLocoNetLine prints a LocoNet message received from the Z21 (RX),
+// sent by the Z21 (TX) or by another LAN client (LAN).
+func printLocoNetLine(f *z21.Frame) {
+	src := ""
+	switch f.Header {
+	case z21.LAN_LOCONET_Z21_RX:
+		src = "RX"
+	case z21.LAN_LOCONET_Z21_TX:
+		src = "TX"
+	case z21.LAN_LOCONET_FROM_LAN:
+		src = "LAN"
+	}
+	fmt.Printf("[LCN] %-3s %s\n", src, formatLocoNet(f.Payload))
+}
+
+func isLocoNetFrame(f *z21.Frame) bool {
+	return f.Header == z21.LAN_LOCONET_Z21_RX ||
+		f.Header == z21.LAN_LOCONET_Z21_TX ||
+		f.Header == z21.LAN_LOCONET_FROM_LAN
+}
+
+// ---------- messages ----------
+
+// LAN_LOCONET_FROM_LAN
+type loconetFromLan struct {
+	Message []byte
+}
+
+func (m *loconetFromLan) Pack() ([]byte, error) {
+	return m.Message, nil
+}
+
+func (m *loconetFromLan) Unpack(data []byte) error {
+	m.Message = data
+	return nil
+}
+
+func (m *loconetFromLan) EncapType() uint16 {
+	return z21.LAN_LOCONET_FROM_LAN
+}
+
+func (m *loconetFromLan) Key() (string, bool) {
+	return "", false
+}
+
+// LAN_LOCONET_DISPATCH_ADDR
+type loconetDispatchAddr struct {
+	Address uint16
+}
+
+func (m *loconetDispatchAddr) Pack() ([]byte, error) {
+	return z21.PackFields(m.Address)
+}
+
+func (m *loconetDispatchAddr) Unpack(data []byte) error {
+	return nil
+}
+
+func (m *loconetDispatchAddr) EncapType() uint16 {
+	return z21.LAN_LOCONET_DISPATCH_ADDR
+}
+
+func (m *loconetDispatchAddr) Key() (string, bool) {
+	return "", false
+}
+
+// LAN_LOCONET_DETECTOR
+type loconetDetector struct {
+	Type    uint8
+	Address uint16
+	Info    []byte
+}
+
+func (m *loconetDetector) Pack() ([]byte, error) {
+	return z21.PackFields(m.Type, m.Address)
+}
+
+func (m *loconetDetector) Unpack(data []byte) error {
+	if len(data) < 3 {
+		return fmt.Errorf("truncated LocoNet detector data")
+	}
+	m.Type = data[0]
+	m.Address = binary.LittleEndian.Uint16(data[1:3])
+	m.Info = data[3:]
+	return nil
+}
+
+func (m *loconetDetector) EncapType() uint16 {
+	return z21.LAN_LOCONET_DETECTOR
+}
+
+func (m *loconetDetector) Key() (string, bool) {
+	return "", false
+}
+
+func (m *loconetDetector) infoUint16() uint16 {
+	if len(m.Info) < 2 {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(m.Info)
+}
+
+// ---------- init ----------
+
+func init() {
+	loconetCmd.AddCommand(
+		loconetSendCmd,
+		loconetDispatchCmd,
+		loconetDetectorCmd,
+	)
+
+	loconetDetectorCmd.Flags().DurationP("timeout", "t", DEFAULT_SCAN_TIMEOUT, "timeout in seconds")
+}
@@ -17,25 +17,72 @@ var monitorCmd = &cobra.Command{
 			return fmt.Errorf("Z21 connection not initialized")
 		}
 
+		rbus := map[uint8]*rbusData{}
+
 		fmt.Println("Waiting for Z21 events ...")
-		for ev := range app.Conn.Events() {
-			switch v := ev.(type) {
-			case *z21.SysData:
-				fmt.Printf(
-					"[SYS] Main: %-5s Prog: %-5s Temp: %-5s Volt: %-5s (%-5s)\n",
-					fmt.Sprintf("%dmA", v.MainCurrent),
-					fmt.Sprintf("%dmA", v.ProgCurrent),
-					fmt.Sprintf("%d°C", v.Temperature),
-					fmt.Sprintf("%sV", mVToVoltString(v.SupplyVoltage)),
-					fmt.Sprintf("%sV", mVToVoltString(v.VccVoltage)),
-				)
-			case *z21.TrackPower:
-				fmt.Printf("[TRK] Power: %s\n",
-					map[bool]string{true: "ON", false: "OFF"}[v.On],
-				)
+		for {
+			select {
+			case ev := <-app.Conn.Events():
+				printEvent(app, ev)
+			case f := <-app.Frames:
+				printFrame(&f, rbus)
 			}
 		}
-
-		return nil
 	},
 }
+
+// printEvent prints a broadcast decoded by z21.go.
+func printEvent(app *AppContext, ev z21.Serializable) {
+	switch v := ev.(type) {
+	case *z21.SysData:
+		fmt.Printf("[SYS] %s\n", formatSystemStatusLine(v))
+	case *z21.CanDetector:
+		printCanDetectorLine(v, app.CanBlocks)
+	case *z21.TrackPower:
+		fmt.Printf("[TRK] Power: %s\n",
+			map[bool]string{true: "ON", false: "OFF"}[v.On],
+		)
+	}
+}
+
+// printFrame prints a broadcast frame not decoded by z21.go. rbus holds the
+// last state of the R-Bus groups to print the changed inputs only.
+func printFrame(f *z21.Frame, rbus map[uint8]*rbusData) {
+	if isLocoNetFrame(f) {
+		printLocoNetLine(f)
+		return
+	}
+	switch f.Header {
+	case z21.LAN_X:
+		l := &locoInfo{}
+		if err := l.Unpack(f.Payload); err != nil {
+			return
+		}
+		fmt.Printf("[LOC] %d: %s\n", l.Address, formatLocoInfo(l))
+	case z21.LAN_RMBUS_DATACHANGED:
+		d := &rbusData{}
+		if err := d.Unpack(f.Payload); err != nil {
+			return
+		}
+		printRbusChanges(rbus[d.Group], d)
+		rbus[d.Group] = d
+	case z21.LAN_RAILCOM_DATACHANGED:
+		d := &railcomData{}
+		if err := d.Unpack(f.Payload); err != nil {
+			return
+		}
+		printRailcomLine(d)
+	case z21.LAN_FAST_CLOCK_DATA:
+		c := &fastClockData{}
+		if err := c.Unpack(f.Payload); err != nil {
+			return
+		}
+		fmt.Printf("[CLK] %s\n", formatFastClock(c))
+	case z21.LAN_LOCONET_DETECTOR:
+		d := &loconetDetector{}
+		if err := d.Unpack(f.Payload); err != nil {
+			return
+		}
+		printLocoNetDetectorLine(d)
+	}
+}
@@ -0,0 +1,485 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trains-io/z21.go"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	EVENT_SHORT_CIRCUIT   string = "short_circuit"
+	EVENT_TRACK_POWER_OFF string = "track_power_off"
+	EVENT_CAN_BUSY        string = "can_busy"
+	EVENT_TEMPERATURE     string = "temperature"
+
+	DEFAULT_ACTION_TIMEOUT time.Duration = 10 * time.Second
+)
+
+var ruleEvents = []struct {
+	name        string
+	description string
+}{
+	{EVENT_SHORT_CIRCUIT, "short circuit on the track"},
+	{EVENT_TRACK_POWER_OFF, "track power switched off"},
+	{EVENT_CAN_BUSY, "CAN detector port becomes busy (--port, --block)"},
+	{EVENT_TEMPERATURE, "temperature rises above a threshold in °C (--above)"},
+}
+
+// RuleSet is the content of a rules file.
+type RuleSet struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// Rule runs its actions whenever its event occurs.
+type Rule struct {
+	Name  string       `yaml:"name"`
+	On    string       `yaml:"on"`
+	Port  string       `yaml:"port"`
+	Block string       `yaml:"block"`
+	Above int          `yaml:"above"`
+	Do    []RuleAction `yaml:"do"`
+
+	netid uint16
+	index uint8
+}
+
+// RuleAction is either a CLI subcommand, a shell command or a webhook URL.
+type RuleAction struct {
+	Run     commandLine `yaml:"run"`
+	Shell   string      `yaml:"shell"`
+	Webhook string      `yaml:"webhook"`
+}
+
+// commandLine is a CLI subcommand given as string or as list of arguments.
+type commandLine []string
+
+func (c *commandLine) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		args, err := splitCommandLine(value.Value)
+		if err != nil {
+			return err
+		}
+		*c = args
+		return nil
+	}
+	var args []string
+	if err := value.Decode(&args); err != nil {
+		return err
+	}
+	*c = args
+	return nil
+}
+
+// ruleEvent is passed to the actions, as environment of shell commands and
+// as body of webhooks.
+type ruleEvent struct {
+	Event       string    `json:"event"`
+	Rule        string    `json:"rule"`
+	Time        time.Time `json:"time"`
+	Port        string    `json:"port,omitempty"`
+	Block       string    `json:"block,omitempty"`
+	Temperature int       `json:"temperature,omitempty"`
+}
+
+func (e *ruleEvent) environ() []string {
+	env := []string{
+		"Z21_EVENT=" + e.Event,
+		"Z21_RULE=" + e.Rule,
+	}
+	if e.Port != "" {
+		env = append(env, "Z21_PORT="+e.Port)
+	}
+	if e.Block != "" {
+		env = append(env, "Z21_BLOCK="+e.Block)
+	}
+	if e.Event == EVENT_TEMPERATURE {
+		env = append(env, "Z21_TEMPERATURE="+strconv.Itoa(e.Temperature))
+	}
+	return env
+}
+
+// on [--rules FILE] | on EVENT do CMD [ARG...]
+var onCmd = &cobra.Command{
+	Use:   "on EVENT do CMD [ARG...]",
+	Short: "Run actions when Z21 events occur",
+	Long: `Run actions when Z21 events occur. The command keeps running on the
+Z21 connection until it is interrupted.
+
+A single rule is given on the command line, its action is a CLI subcommand:
+
+  z21cli on short_circuit do power off
+  z21cli on --block "Yard 1" can_busy do loco stop 3
+
+Flags must be given before EVENT. Several rules with shell commands and
+webhooks are read from a YAML file with --rules:
+
+  rules:
+    - name: cut power
+      on: short_circuit
+      do:
+        - run: power off
+        - webhook: http://localhost:8080/z21
+    - on: can_busy
+      block: Yard 1
+      do:
+        - shell: echo "$Z21_BLOCK busy"
+    - on: temperature
+      above: 60
+      do:
+        - run: [power, off]
+
+Shell commands and webhooks are stopped after --timeout, the events are not
+read while an action runs.
+
+Events:
+` + formatRuleEvents(),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file, _ := cmd.Flags().GetString("rules")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+
+		if timeout <= 0 {
+			return fmt.Errorf("invalid timeout %s", timeout)
+		}
+
+		var rules []Rule
+		if file != "" {
+			if len(args) > 0 {
+				return fmt.Errorf("either --rules or EVENT do CMD, not both")
+			}
+			r, err := loadRules(file)
+			if err != nil {
+				return err
+			}
+			rules = r
+		} else {
+			r, err := parseRuleArgs(cmd, args)
+			if err != nil {
+				return err
+			}
+			rules = []Rule{r}
+		}
+
+		for i := range rules {
+			if err := rules[i].validate(i); err != nil {
+				return err
+			}
+		}
+
+		app := GetAppContext(cmd)
+		if app == nil || app.Conn == nil {
+			return fmt.Errorf("Z21 connection not initialized")
+		}
+
+		flags := z21.TRACK_UPDATES
+		for _, r := range rules {
+			switch r.On {
+			case EVENT_CAN_BUSY:
+				flags |= z21.CAN_DETECTOR_UPDATES
+			case EVENT_SHORT_CIRCUIT, EVENT_TEMPERATURE:
+				flags |= z21.SYSTEM_UPDATES
+			}
+		}
+		if err := subscribe(app.Conn, flags); err != nil {
+			return err
+		}
+
+		fmt.Printf("Waiting for Z21 events (%d rule(s)) ...\n", len(rules))
+		return newRuleEngine(app, rules, timeout).run()
+	},
+}
+
+func loadRules(file string) ([]Rule, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var rs RuleSet
+	if err := yaml.Unmarshal(data, &rs); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	if len(rs.Rules) == 0 {
+		return nil, fmt.Errorf("%s: no rules defined", file)
+	}
+	return rs.Rules, nil
+}
+
+// parseRuleArgs parses EVENT do CMD [ARG...].
+func parseRuleArgs(cmd *cobra.Command, args []string) (Rule, error) {
+	if len(args) < 3 || args[1] != "do" {
+		return Rule{}, fmt.Errorf("expected EVENT do CMD [ARG...] or --rules FILE")
+	}
+	port, _ := cmd.Flags().GetString("port")
+	block, _ := cmd.Flags().GetString("block")
+	above, _ := cmd.Flags().GetInt("above")
+
+	return Rule{
+		Name:  args[0],
+		On:    args[0],
+		Port:  port,
+		Block: block,
+		Above: above,
+		Do:    []RuleAction{{Run: args[2:]}},
+	}, nil
+}
+
+func (r *Rule) validate(i int) error {
+	if r.Name == "" {
+		r.Name = fmt.Sprintf("rule %d", i+1)
+	}
+
+	known := false
+	for _, e := range ruleEvents {
+		known = known || e.name == r.On
+	}
+	if !known {
+		return fmt.Errorf("%s: unknown event %q", r.Name, r.On)
+	}
+	if r.On != EVENT_CAN_BUSY && (r.Port != "" || r.Block != "") {
+		return fmt.Errorf("%s: port and block only apply to %s", r.Name, EVENT_CAN_BUSY)
+	}
+	if r.On == EVENT_TEMPERATURE && r.Above <= 0 {
+		return fmt.Errorf("%s: %s needs a threshold above 0°C", r.Name, EVENT_TEMPERATURE)
+	}
+	if r.Port != "" {
+		netid, index, err := parseCanPort(r.Port)
+		if err != nil {
+			return fmt.Errorf("%s: %w", r.Name, err)
+		}
+		r.netid, r.index = netid, index
+	}
+
+	if len(r.Do) == 0 {
+		return fmt.Errorf("%s: no actions defined", r.Name)
+	}
+	for _, a := range r.Do {
+		n := 0
+		for _, set := range []bool{len(a.Run) > 0, a.Shell != "", a.Webhook != ""} {
+			if set {
+				n++
+			}
+		}
+		if n != 1 {
+			return fmt.Errorf("%s: an action needs exactly one of run, shell or webhook", r.Name)
+		}
+		if len(a.Run) > 0 {
+			if _, _, err := findCommand(a.Run); err != nil {
+				return fmt.Errorf("%s: %w", r.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (a *RuleAction) String() string {
+	switch {
+	case len(a.Run) > 0:
+		return "run " + strings.Join(a.Run, " ")
+	case a.Shell != "":
+		return "shell " + a.Shell
+	default:
+		return "webhook " + a.Webhook
+	}
+}
+
+// ruleEngine tracks the state needed to detect the events of its rules.
+type ruleEngine struct {
+	app     *AppContext
+	rules   []Rule
+	timeout time.Duration // of shell commands and webhooks
+	off     bool
+	short   bool
+	hot     map[int]bool
+	busy    map[canPortKey]bool
+}
+
+func newRuleEngine(app *AppContext, rules []Rule, timeout time.Duration) *ruleEngine {
+	return &ruleEngine{
+		app:     app,
+		rules:   rules,
+		timeout: timeout,
+		hot:     map[int]bool{},
+		busy:    map[canPortKey]bool{},
+	}
+}
+
+// run reads the events of the connection. Short circuits are reported by the
+// LAN_X_BC_TRACK_SHORT_CIRCUIT broadcast and by the central state of the
+// system state broadcast, a short circuit fires once until the track power is
+// switched on again.
+func (e *ruleEngine) run() error {
+	for {
+		select {
+		case ev := <-e.app.Conn.Events():
+			switch v := ev.(type) {
+			case *z21.TrackPower:
+				if v.On {
+					e.off, e.short = false, false
+					continue
+				}
+				if !e.off {
+					e.fire(&ruleEvent{Event: EVENT_TRACK_POWER_OFF})
+				}
+				e.off = true
+			case *z21.SysData:
+				if v.CentralState.Has(z21.SHORT_CIRCUIT) {
+					e.shortCircuit()
+				}
+				e.updateTemperature(int(v.Temperature))
+			case *z21.CanDetector:
+				e.updateCanPort(v)
+			}
+		case f := <-e.app.Frames:
+			if isXFrame(&f, z21.LAN_X_61, z21.LAN_X_BC_TRACK_SHORT_CIRCUIT) {
+				e.shortCircuit()
+			}
+		}
+	}
+}
+
+func (e *ruleEngine) shortCircuit() {
+	if !e.short {
+		e.short = true
+		e.fire(&ruleEvent{Event: EVENT_SHORT_CIRCUIT})
+	}
+}
+
+// updateTemperature fires the temperature rules once when the threshold is
+// exceeded, they fire again after the temperature dropped below.
+func (e *ruleEngine) updateTemperature(temp int) {
+	for i := range e.rules {
+		r := &e.rules[i]
+		if r.On != EVENT_TEMPERATURE {
+			continue
+		}
+		hot := temp > r.Above
+		if hot && !e.hot[i] {
+			e.run1(r, &ruleEvent{Event: EVENT_TEMPERATURE, Temperature: temp})
+		}
+		e.hot[i] = hot
+	}
+}
+
+func (e *ruleEngine) updateCanPort(v *z21.CanDetector) {
+	if v.Type != z21.CANMessageTypeStatus {
+		return
+	}
+	key := canPortKey{v.NetworkID, v.Port}
+	busy := isPortBusy(v.Value1)
+	wasBusy := e.busy[key]
+	e.busy[key] = busy
+	if !busy || wasBusy {
+		return
+	}
+
+	port := formatCanPort(v.NetworkID, v.Port)
+	block := e.app.CanBlocks[port]
+	for i := range e.rules {
+		r := &e.rules[i]
+		if r.On != EVENT_CAN_BUSY {
+			continue
+		}
+		if r.Port != "" && (r.netid != v.NetworkID || r.index != v.Port) {
+			continue
+		}
+		if r.Block != "" && r.Block != block {
+			continue
+		}
+		e.run1(r, &ruleEvent{Event: EVENT_CAN_BUSY, Port: port, Block: block})
+	}
+}
+
+// fire runs all rules of the event of ev.
+func (e *ruleEngine) fire(ev *ruleEvent) {
+	for i := range e.rules {
+		if e.rules[i].On == ev.Event {
+			e.run1(&e.rules[i], ev)
+		}
+	}
+}
+
+// run1 runs the actions of a rule one after the other. A failing action is
+// reported and does not stop the following ones.
+func (e *ruleEngine) run1(r *Rule, ev *ruleEvent) {
+	ev.Rule = r.Name
+	ev.Time = time.Now()
+	for _, a := range r.Do {
+		fmt.Printf("[RUL] %s: %s -> %s\n", r.Name, ev.Event, a.String())
+		var err error
+		switch {
+		case len(a.Run) > 0:
+			err = execCommand(e.app, a.Run)
+		case a.Shell != "":
+			err = runShell(a.Shell, ev, e.timeout)
+		default:
+			err = postWebhook(a.Webhook, ev, e.timeout)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[RUL] %s: %s failed: %s\n", r.Name, a.String(), err)
+		}
+	}
+}
+
+// runShell runs command and kills it after timeout.
+func runShell(command string, ev *ruleEvent, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	c := exec.CommandContext(ctx, "sh", "-c", command)
+	c.Env = append(os.Environ(), ev.environ()...)
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	// background processes of the command keep the output open
+	c.WaitDelay = time.Second
+	err := c.Run()
+	if ctx.Err() != nil {
+		return fmt.Errorf("killed after %s", timeout)
+	}
+	return err
+}
+
+func postWebhook(url string, ev *ruleEvent, timeout time.Duration) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+func formatRuleEvents() string {
+	var b strings.Builder
+	for _, e := range ruleEvents {
+		fmt.Fprintf(&b, "  %-16s %s\n", e.name, e.description)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// ---------- init ----------
+
+func init() {
+	onCmd.Flags().SetInterspersed(false)
+	onCmd.Flags().StringP("rules", "r", "", "YAML file with rules")
+	onCmd.Flags().String("port", "", "only trigger can_busy for this port (NETID:PORT)")
+	onCmd.Flags().String("block", "", "only trigger can_busy for this named block")
+	onCmd.Flags().Int("above", 0, "temperature threshold in °C")
+	onCmd.Flags().Duration("timeout", DEFAULT_ACTION_TIMEOUT, "timeout of shell commands and webhooks")
+}
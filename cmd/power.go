@@ -14,25 +14,20 @@ var powerCmd = &cobra.Command{
 
 var powerOnCmd = &cobra.Command{
 	Use:   "on",
-	Short: "Turn track power on",
+	Short: "Turn track power on, or the power of a CAN booster",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		app := GetAppContext(cmd)
 		if app == nil || app.Conn == nil {
 			return fmt.Errorf("Z21 connection not initialized")
 		}
 
-		_, err := Req(app.Conn, &z21.BroadcastFlags{Flags: z21.Mask32(z21.TRACK_UPDATES)})
-		if err != nil {
-			return err
+		if cmd.Flags().Changed("booster") {
+			return setBoosterPowerFromFlags(cmd, app, true)
 		}
 
-		st, err := Req(app.Conn, &z21.TrackPower{On: true})
-		if err != nil {
+		if err := setTrackPower(app, true); err != nil {
 			return err
 		}
-		if !st.On {
-			return fmt.Errorf("failed to turn power on")
-		}
 		fmt.Printf("Track power is turned on.\n")
 		return nil
 	},
@@ -40,25 +35,20 @@ var powerOnCmd = &cobra.Command{
 
 var powerOffCmd = &cobra.Command{
 	Use:   "off",
-	Short: "Turn track power off",
+	Short: "Turn track power off, or the power of a CAN booster",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		app := GetAppContext(cmd)
 		if app == nil || app.Conn == nil {
 			return fmt.Errorf("Z21 connection not initialized")
 		}
 
-		_, err := Req(app.Conn, &z21.BroadcastFlags{Flags: z21.Mask32(z21.TRACK_UPDATES)})
-		if err != nil {
-			return err
+		if cmd.Flags().Changed("booster") {
+			return setBoosterPowerFromFlags(cmd, app, false)
 		}
 
-		st, err := Req(app.Conn, &z21.TrackPower{On: false})
-		if err != nil {
+		if err := setTrackPower(app, false); err != nil {
 			return err
 		}
-		if st.On {
-			return fmt.Errorf("failed to turn power off")
-		}
 		fmt.Printf("Track power is turned off.\n")
 		return nil
 	},
@@ -74,13 +64,11 @@ var powerStopCmd = &cobra.Command{
 			return fmt.Errorf("Z21 connection not initialized")
 		}
 
-		_, err := Req(app.Conn, &z21.BroadcastFlags{Flags: z21.Mask32(z21.TRACK_UPDATES)})
-		if err != nil {
+		if err := subscribe(app.Conn, z21.TRACK_UPDATES); err != nil {
 			return err
 		}
 
-		_, err = Req(app.Conn, &z21.Stop{})
-		if err != nil {
+		if _, err := Req(app.Conn, &z21.Stop{}); err != nil {
 			return err
 		}
 		fmt.Printf("Emergency stop is activated! The locomotives are stopped but the track voltage remains switched on.\n")
@@ -88,10 +76,43 @@ var powerStopCmd = &cobra.Command{
 	},
 }
 
+// setTrackPower switches the track power. The reply is only sent with
+// TRACK_UPDATES subscribed, other subscriptions are kept.
+func setTrackPower(app *AppContext, on bool) error {
+	if err := subscribe(app.Conn, z21.TRACK_UPDATES); err != nil {
+		return err
+	}
+
+	st, err := Req(app.Conn, &z21.TrackPower{On: on})
+	if err != nil {
+		return err
+	}
+	if st.On != on {
+		return fmt.Errorf("failed to turn power %s", map[bool]string{true: "on", false: "off"}[on])
+	}
+	return nil
+}
+
+func setBoosterPowerFromFlags(cmd *cobra.Command, app *AppContext, on bool) error {
+	booster, _ := cmd.Flags().GetString("booster")
+	port, _ := cmd.Flags().GetUint8("port")
+
+	netid, err := parseNetID(booster)
+	if err != nil {
+		return err
+	}
+	return setBoosterPower(app, netid, port, on)
+}
+
 func init() {
 	powerCmd.AddCommand(
 		powerOnCmd,
 		powerOffCmd,
 		powerStopCmd,
 	)
+
+	for _, c := range []*cobra.Command{powerOnCmd, powerOffCmd} {
+		c.Flags().String("booster", "", "NetID of a CAN booster to switch instead of the track power")
+		c.Flags().Uint8("port", 0, "output port of a dual booster (1-2), default all")
+	}
 }
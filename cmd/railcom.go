@@ -0,0 +1,263 @@
+package cmd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+	"github.com/trains-io/z21.go"
+)
+
+const (
+	RAILCOM_TYPE_POLL uint8 = 0x01
+)
+
+const (
+	RCO_SPEED1 uint8 = 0x01 // bit 0
+	RCO_SPEED2 uint8 = 0x02 // bit 1
+	RCO_QOS    uint8 = 0x04 // bit 2
+)
+
+var railcomCmd = &cobra.Command{
+	Use:   "railcom",
+	Short: "Show RailCom data of locos",
+}
+
+// ---------- subcommands ----------
+
+// get ADDR
+var railcomGetCmd = &cobra.Command{
+	Use:   "get ADDR",
+	Short: "Show RailCom data of a loco",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		val, err := strconv.ParseUint(args[0], 0, 16)
+		if err != nil {
+			return err
+		}
+		addr := uint16(val)
+
+		app := GetAppContext(cmd)
+		if app == nil || app.Conn == nil {
+			return fmt.Errorf("Z21 connection not initialized")
+		}
+
+		f, err := ReqFrame(app, &railcomGetData{Address: addr}, DEFAULT_REQ_TIMEOUT, func(f *z21.Frame) bool {
+			return f.Header == z21.LAN_RAILCOM_DATACHANGED &&
+				len(f.Payload) >= 2 &&
+				binary.LittleEndian.Uint16(f.Payload) == addr
+		})
+		if err != nil {
+			return err
+		}
+
+		d := &railcomData{}
+		if err := d.Unpack(f.Payload); err != nil {
+			return err
+		}
+		printRailcomData(d)
+		return nil
+	},
+}
+
+// watch [ADDR...] [--all]
+var railcomWatchCmd = &cobra.Command{
+	Use:   "watch [ADDR...]",
+	Short: "Watch RailCom data of subscribed locos",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		all, _ := cmd.Flags().GetBool("all")
+
+		addrs := []uint16{}
+		for _, a := range args {
+			val, err := strconv.ParseUint(a, 0, 16)
+			if err != nil {
+				return err
+			}
+			addrs = append(addrs, uint16(val))
+		}
+
+		app := GetAppContext(cmd)
+		if app == nil || app.Conn == nil {
+			return fmt.Errorf("Z21 connection not initialized")
+		}
+
+		flag := z21.RAILCOM_SUB_UPDATES
+		if all {
+			flag = z21.RAILCOM_UPDATES
+		}
+		if err := subscribe(app.Conn, flag); err != nil {
+			return err
+		}
+
+		// RAILCOM_SUB_UPDATES covers the locos this session asked info for
+		for _, a := range addrs {
+			if _, err := Req(app.Conn, &locoInfoReq{Address: a}); err != nil {
+				return err
+			}
+		}
+
+		fmt.Println("Waiting for RailCom data ...")
+		for f := range app.Frames {
+			if f.Header != z21.LAN_RAILCOM_DATACHANGED {
+				continue
+			}
+			d := &railcomData{}
+			if err := d.Unpack(f.Payload); err != nil {
+				continue
+			}
+			if len(addrs) > 0 && !containsAddress(addrs, d.Address) {
+				continue
+			}
+			printRailcomLine(d)
+		}
+		return nil
+	},
+}
+
+func containsAddress(addrs []uint16, addr uint16) bool {
+	for _, a := range addrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func printRailcomData(d *railcomData) {
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.Style().Options.DrawBorder = false
+	t.Style().Options.SeparateColumns = false
+	t.AppendHeader(table.Row{"Loco", "Received", "Errors", "Error Rate", "Speed", "QoS"})
+	t.AppendRow(
+		table.Row{
+			fmt.Sprintf("%d", d.Address),
+			fmt.Sprintf("%d", d.ReceiveCounter),
+			fmt.Sprintf("%d", d.ErrorCounter),
+			formatRailcomErrorRate(d),
+			formatRailcomSpeed(d),
+			formatRailcomQos(d),
+		},
+	)
+	t.Render()
+}
+
+func printRailcomLine(d *railcomData) {
+	fmt.Printf(
+		"[RCN] Loco: %-5d Rx: %-8d Err: %-6d (%-6s) Speed: %-4s QoS: %s\n",
+		d.Address,
+		d.ReceiveCounter,
+		d.ErrorCounter,
+		formatRailcomErrorRate(d),
+		formatRailcomSpeed(d),
+		formatRailcomQos(d),
+	)
+}
+
+func formatRailcomErrorRate(d *railcomData) string {
+	total := uint64(d.ReceiveCounter) + uint64(d.ErrorCounter)
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", float64(d.ErrorCounter)*100/float64(total))
+}
+
+func formatRailcomSpeed(d *railcomData) string {
+	if d.Options&(RCO_SPEED1|RCO_SPEED2) == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%d", d.Speed)
+}
+
+func formatRailcomQos(d *railcomData) string {
+	if d.Options&RCO_QOS == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%d", d.QoS)
+}
+
+// ---------- messages ----------
+
+// LAN_RAILCOM_GETDATA
+type railcomGetData struct {
+	Address uint16
+}
+
+func (m *railcomGetData) Pack() ([]byte, error) {
+	return z21.PackFields(RAILCOM_TYPE_POLL, m.Address)
+}
+
+func (m *railcomGetData) Unpack(data []byte) error {
+	return nil
+}
+
+func (m *railcomGetData) EncapType() uint16 {
+	return z21.LAN_RAILCOM_GETDATA
+}
+
+func (m *railcomGetData) Key() (string, bool) {
+	return "", false
+}
+
+// LAN_RAILCOM_DATACHANGED
+type railcomData struct {
+	Address        uint16
+	ReceiveCounter uint32
+	ErrorCounter   uint16
+	Reserved1      uint8
+	Options        uint8
+	Speed          uint8
+	QoS            uint8
+	Reserved2      uint8
+}
+
+func (m *railcomData) Unpack(data []byte) error {
+	return z21.UnpackFields(
+		data,
+		&m.Address,
+		&m.ReceiveCounter,
+		&m.ErrorCounter,
+		&m.Reserved1,
+		&m.Options,
+		&m.Speed,
+		&m.QoS,
+		&m.Reserved2,
+	)
+}
+
+// LAN_X_GET_LOCO_INFO
+type locoInfoReq struct {
+	Address uint16
+}
+
+func (m *locoInfoReq) Pack() ([]byte, error) {
+	msb, lsb := packLocoAddress(m.Address)
+	b := []byte{z21.LAN_X_E3, z21.LAN_X_GET_LOCO_INFO, msb, lsb}
+	return append(b, xor(b)), nil
+}
+
+func (m *locoInfoReq) Unpack(data []byte) error {
+	return nil
+}
+
+func (m *locoInfoReq) EncapType() uint16 {
+	return z21.LAN_X
+}
+
+func (m *locoInfoReq) Key() (string, bool) {
+	return "", false
+}
+
+// ---------- init ----------
+
+func init() {
+	railcomCmd.AddCommand(
+		railcomGetCmd,
+		railcomWatchCmd,
+	)
+
+	railcomWatchCmd.Flags().Bool("all", false, "watch all locos (RAILCOM_UPDATES), use with caution")
+}
@@ -0,0 +1,242 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+	"github.com/trains-io/z21.go"
+)
+
+const (
+	RBUS_GROUPS        uint8 = 2
+	RBUS_GROUP_MODULES int   = 10
+	RBUS_MODULE_INPUTS int   = 8
+)
+
+var rbusCmd = &cobra.Command{
+	Use:   "rbus",
+	Short: "Manage R-Bus feedback modules",
+}
+
+// ---------- subcommands ----------
+
+// status [GROUP]
+var rbusStatusCmd = &cobra.Command{
+	Use:   "status [GROUP]",
+	Short: "Show R-Bus feedback module inputs",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		groups := []uint8{}
+		if len(args) == 1 {
+			val, err := strconv.ParseUint(args[0], 0, 8)
+			if err != nil {
+				return err
+			}
+			if uint8(val) >= RBUS_GROUPS {
+				return fmt.Errorf("invalid R-Bus group %d (0-%d)", val, RBUS_GROUPS-1)
+			}
+			groups = append(groups, uint8(val))
+		} else {
+			for g := uint8(0); g < RBUS_GROUPS; g++ {
+				groups = append(groups, g)
+			}
+		}
+
+		app := GetAppContext(cmd)
+		if app == nil || app.Conn == nil {
+			return fmt.Errorf("Z21 connection not initialized")
+		}
+
+		data := []*rbusData{}
+		for _, g := range groups {
+			d, err := getRbusData(app, g)
+			if err != nil {
+				return err
+			}
+			data = append(data, d)
+		}
+
+		printRbusData(data)
+		return nil
+	},
+}
+
+// program ADDR
+var rbusProgramCmd = &cobra.Command{
+	Use:   "program ADDR",
+	Short: "Program the address of an R-Bus feedback module",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		val, err := strconv.ParseUint(args[0], 0, 8)
+		if err != nil {
+			return err
+		}
+		addr := uint8(val)
+		if int(addr) > int(RBUS_GROUPS)*RBUS_GROUP_MODULES {
+			return fmt.Errorf("invalid R-Bus address %d (1-%d)", addr, int(RBUS_GROUPS)*RBUS_GROUP_MODULES)
+		}
+
+		app := GetAppContext(cmd)
+		if app == nil || app.Conn == nil {
+			return fmt.Errorf("Z21 connection not initialized")
+		}
+
+		_, err = Req(app.Conn, &rbusProgramModule{Address: addr})
+		if err != nil {
+			return err
+		}
+
+		if addr == 0 {
+			fmt.Printf("R-Bus programming mode finished.\n")
+			return nil
+		}
+		fmt.Printf("R-Bus programming of address %d started. Press the programming button on the module, then run `rbus program 0`.\n", addr)
+		return nil
+	},
+}
+
+func getRbusData(app *AppContext, group uint8) (*rbusData, error) {
+	f, err := ReqFrame(app, &rbusGetData{Group: group}, DEFAULT_REQ_TIMEOUT, func(f *z21.Frame) bool {
+		return f.Header == z21.LAN_RMBUS_DATACHANGED && len(f.Payload) > 0 && f.Payload[0] == group
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	d := &rbusData{}
+	if err := d.Unpack(f.Payload); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func printRbusData(data []*rbusData) {
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.Style().Options.DrawBorder = false
+	t.Style().Options.SeparateColumns = false
+
+	header := table.Row{"Module"}
+	for i := 1; i <= RBUS_MODULE_INPUTS; i++ {
+		header = append(header, fmt.Sprintf("%d", i))
+	}
+	t.AppendHeader(header)
+
+	for _, d := range data {
+		for i, status := range d.Status {
+			row := table.Row{fmt.Sprintf("%d", d.module(i))}
+			for input := 0; input < RBUS_MODULE_INPUTS; input++ {
+				row = append(row, formatRbusInput(status&(1<<input) != 0))
+			}
+			t.AppendRow(row)
+		}
+	}
+	t.Render()
+}
+
+func formatRbusInput(busy bool) string {
+	if busy {
+		return "X"
+	}
+	return "-"
+}
+
+// printRbusChanges prints the inputs that differ between the previous and the
+// current data of an R-Bus group. prev may be nil.
+func printRbusChanges(prev, cur *rbusData) {
+	ts := time.Now().Format("15:04:05.000")
+	for i, status := range cur.Status {
+		var old uint8
+		if prev != nil {
+			old = prev.Status[i]
+		}
+		changed := status ^ old
+		for input := 0; input < RBUS_MODULE_INPUTS; input++ {
+			if changed&(1<<input) == 0 {
+				continue
+			}
+			state := "free"
+			if status&(1<<input) != 0 {
+				state = "busy"
+			}
+			fmt.Printf("[RBS] %s Module: %-3d Input: %d %s\n", ts, cur.module(i), input+1, state)
+		}
+	}
+}
+
+// ---------- messages ----------
+
+// LAN_RMBUS_GETDATA
+type rbusGetData struct {
+	Group uint8
+}
+
+func (m *rbusGetData) Pack() ([]byte, error) {
+	return []byte{m.Group}, nil
+}
+
+func (m *rbusGetData) Unpack(data []byte) error {
+	return nil
+}
+
+func (m *rbusGetData) EncapType() uint16 {
+	return z21.LAN_RMBUS_GETDATA
+}
+
+func (m *rbusGetData) Key() (string, bool) {
+	return "", false
+}
+
+// LAN_RMBUS_PROGRAMMODULE
+type rbusProgramModule struct {
+	Address uint8
+}
+
+func (m *rbusProgramModule) Pack() ([]byte, error) {
+	return []byte{m.Address}, nil
+}
+
+func (m *rbusProgramModule) Unpack(data []byte) error {
+	return nil
+}
+
+func (m *rbusProgramModule) EncapType() uint16 {
+	return z21.LAN_RMBUS_PROGRAMMODULE
+}
+
+func (m *rbusProgramModule) Key() (string, bool) {
+	return "", false
+}
+
+// LAN_RMBUS_DATACHANGED
+type rbusData struct {
+	Group  uint8
+	Status [RBUS_GROUP_MODULES]uint8
+}
+
+func (m *rbusData) Unpack(data []byte) error {
+	if len(data) < 1+RBUS_GROUP_MODULES {
+		return fmt.Errorf("truncated R-Bus data")
+	}
+	m.Group = data[0]
+	copy(m.Status[:], data[1:])
+	return nil
+}
+
+// module returns the 1-based address of the i-th module of the group.
+func (m *rbusData) module(i int) int {
+	return int(m.Group)*RBUS_GROUP_MODULES + i + 1
+}
+
+// ---------- init ----------
+
+func init() {
+	rbusCmd.AddCommand(
+		rbusStatusCmd,
+		rbusProgramCmd,
+	)
+}
@@ -17,10 +17,14 @@ type ctxkey string
 
 type AppContext struct {
 	Conn        *z21.Conn
+	Frames      <-chan z21.Frame
 	ContextName string
 	Host        string
 	Port        int
 	Session     *SessionInfo
+	CanBlocks   map[string]string
+	CanDevices  map[uint16]bool // NetIDs of the CAN devices seen so far
+	Locos       map[uint16]bool // addresses of the locos seen so far
 	Resumed     bool
 	Logger      zerolog.Logger
 }
@@ -94,10 +98,11 @@ func InitAppContext(cmd *cobra.Command) error {
 		dialer = &net.Dialer{}
 	}
 
+	frames := make(chan z21.Frame, DEFAULT_FRAME_BUF_SIZE)
 	conn, err := z21.Connect(
 		c.Host,
 		z21.Verbose(verbose),
-		z21.SetCustomDialer(dialer),
+		z21.SetCustomDialer(&frameTap{dialer: dialer, frames: frames}),
 	)
 	if err != nil {
 		return fmt.Errorf("failed to connect to Z21: %w", err)
@@ -109,9 +114,13 @@ func InitAppContext(cmd *cobra.Command) error {
 	}
 
 	appCtx.Conn = conn
+	appCtx.Frames = frames
 	appCtx.ContextName = c.Name
 	appCtx.Host = c.Host
 	appCtx.Port = c.Port
+	appCtx.CanBlocks = c.CanBlocks
+	appCtx.CanDevices = map[uint16]bool{}
+	appCtx.Locos = map[uint16]bool{}
 	appCtx.Session = &SessionInfo{
 		LocalHost: localHost,
 		LocalPort: localPort,
@@ -186,4 +195,21 @@ func init() {
 	rootCmd.AddCommand(statusCmd)
 	rootCmd.AddCommand(powerCmd)
 	rootCmd.AddCommand(canCmd)
+	rootCmd.AddCommand(decoderCmd)
+	rootCmd.AddCommand(rbusCmd)
+	rootCmd.AddCommand(railcomCmd)
+	rootCmd.AddCommand(clockCmd)
+	rootCmd.AddCommand(loconetCmd)
+	rootCmd.AddCommand(boosterCmd)
+	rootCmd.AddCommand(layoutCmd)
+	rootCmd.AddCommand(routeCmd)
+	rootCmd.AddCommand(accCmd)
+	rootCmd.AddCommand(locoCmd)
+	rootCmd.AddCommand(runCmd)
+	rootCmd.AddCommand(onCmd)
+	rootCmd.AddCommand(batchCmd)
+	rootCmd.AddCommand(shellCmd)
+	rootCmd.AddCommand(serveCmd)
+	rootCmd.AddCommand(bridgeCmd)
+	rootCmd.AddCommand(exporterCmd)
 }
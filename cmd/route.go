@@ -0,0 +1,278 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+)
+
+const (
+	DEFAULT_ROUTE_SPACING time.Duration = 200 * time.Millisecond
+)
+
+// RouteInfo is a named list of turnout positions saved with a context.
+type RouteInfo struct {
+	Name     string         `json:"name"`
+	Turnouts []RouteTurnout `json:"turnouts"`
+	Blocks   []string       `json:"blocks,omitempty"`
+}
+
+type RouteTurnout struct {
+	Address  uint16 `json:"address"`
+	Position string `json:"position"`
+}
+
+var routeCmd = &cobra.Command{
+	Use:   "route",
+	Short: "Manage and set turnout routes",
+}
+
+// ---------- subcommands ----------
+
+// add NAME ADDR:POS... [--block NAME]...
+var routeAddCmd = &cobra.Command{
+	Use:   "add NAME ADDR:straight|diverging...",
+	Short: "Add or replace a route",
+	Args:  cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		blocks, _ := cmd.Flags().GetStringArray("block")
+
+		route := RouteInfo{Name: args[0], Blocks: blocks}
+		for _, a := range args[1:] {
+			t, err := parseRouteTurnout(a)
+			if err != nil {
+				return err
+			}
+			route.Turnouts = append(route.Turnouts, t)
+		}
+
+		c, err := loadCurrentContext()
+		if err != nil {
+			return err
+		}
+
+		err = updateContext(c.Name, func(c *ContextInfo) {
+			for i := range c.Routes {
+				if c.Routes[i].Name == route.Name {
+					c.Routes[i] = route
+					return
+				}
+			}
+			c.Routes = append(c.Routes, route)
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Route %q with %d turnout(s) saved\n", route.Name, len(route.Turnouts))
+		return nil
+	},
+}
+
+// list | ls
+var routeListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "List all routes",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := loadCurrentContext()
+		if err != nil {
+			return err
+		}
+
+		if len(c.Routes) == 0 {
+			fmt.Println("No routes saved")
+			return nil
+		}
+
+		t := table.NewWriter()
+		t.SetOutputMirror(os.Stdout)
+		t.Style().Options.DrawBorder = false
+		t.Style().Options.SeparateColumns = false
+		t.AppendHeader(table.Row{"Route", "Turnouts", "Blocks"})
+		for _, r := range c.Routes {
+			turnouts := []string{}
+			for _, to := range r.Turnouts {
+				turnouts = append(turnouts, fmt.Sprintf("%d:%s", to.Address, to.Position))
+			}
+			t.AppendRow(
+				table.Row{
+					r.Name,
+					strings.Join(turnouts, " "),
+					strings.Join(r.Blocks, ", "),
+				},
+			)
+		}
+		t.Render()
+		return nil
+	},
+}
+
+// rm NAME
+var routeRmCmd = &cobra.Command{
+	Use:   "rm NAME",
+	Short: "Remove a route",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+
+		c, err := loadCurrentContext()
+		if err != nil {
+			return err
+		}
+		if findRoute(c, name) == nil {
+			return fmt.Errorf("route %q not found", name)
+		}
+
+		err = updateContext(c.Name, func(c *ContextInfo) {
+			routes := []RouteInfo{}
+			for _, r := range c.Routes {
+				if r.Name != name {
+					routes = append(routes, r)
+				}
+			}
+			c.Routes = routes
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Route %q removed\n", name)
+		return nil
+	},
+}
+
+// set NAME [--spacing DURATION] [--force]
+var routeSetCmd = &cobra.Command{
+	Use:   "set NAME",
+	Short: "Set the turnouts of a route",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		spacing, _ := cmd.Flags().GetDuration("spacing")
+		force, _ := cmd.Flags().GetBool("force")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+
+		app := GetAppContext(cmd)
+		if app == nil || app.Conn == nil {
+			return fmt.Errorf("Z21 connection not initialized")
+		}
+
+		c, err := loadCurrentContext()
+		if err != nil {
+			return err
+		}
+		route := findRoute(c, args[0])
+		if route == nil {
+			return fmt.Errorf("route %q not found", args[0])
+		}
+
+		return setRoute(app, c, route, spacing, force, timeout)
+	},
+}
+
+// setRoute checks the blocks of the route unless force is set and switches
+// its turnouts one after the other.
+func setRoute(app *AppContext, c *ContextInfo, route *RouteInfo, spacing time.Duration, force bool, timeout time.Duration) error {
+	if len(route.Blocks) > 0 && !force {
+		if err := checkRouteBlocks(app, c, route, timeout); err != nil {
+			return err
+		}
+	}
+
+	for i, t := range route.Turnouts {
+		if i > 0 {
+			time.Sleep(spacing)
+		}
+		pos, _ := parseTurnoutPosition(t.Position)
+		if err := setTurnout(app, t.Address, pos); err != nil {
+			return fmt.Errorf("turnout %d: %w", t.Address, err)
+		}
+		fmt.Printf("Turnout %-5d %s\n", t.Address, t.Position)
+	}
+
+	fmt.Printf("Route %q set.\n", route.Name)
+	return nil
+}
+
+// checkRouteBlocks returns an error unless all blocks covered by the route
+// are free.
+func checkRouteBlocks(app *AppContext, c *ContextInfo, route *RouteInfo, timeout time.Duration) error {
+	m := newLayoutModel(c)
+	if err := m.load(app, timeout); err != nil {
+		return err
+	}
+
+	notFree := []string{}
+	for _, name := range route.Blocks {
+		var block *layoutBlock
+		for _, b := range m.blocks {
+			if b.Name == name {
+				block = b
+			}
+		}
+		if block == nil {
+			notFree = append(notFree, fmt.Sprintf("%s (not mapped)", name))
+			continue
+		}
+		if st := block.state(); st != BLOCK_FREE {
+			notFree = append(notFree, fmt.Sprintf("%s (%s)", name, st))
+		}
+	}
+
+	if len(notFree) > 0 {
+		return fmt.Errorf("route %q not set, blocks not free: %s (use --force to set anyway)",
+			route.Name, strings.Join(notFree, ", "))
+	}
+	return nil
+}
+
+func findRoute(c *ContextInfo, name string) *RouteInfo {
+	for i := range c.Routes {
+		if c.Routes[i].Name == name {
+			return &c.Routes[i]
+		}
+	}
+	return nil
+}
+
+// parseRouteTurnout parses ADDR:straight|diverging.
+func parseRouteTurnout(s string) (RouteTurnout, error) {
+	a, p, ok := strings.Cut(s, ":")
+	if !ok {
+		return RouteTurnout{}, fmt.Errorf("invalid turnout %q, expected ADDR:straight|diverging", s)
+	}
+	addr, err := parseTurnoutAddress(a)
+	if err != nil {
+		return RouteTurnout{}, err
+	}
+	pos, err := parseTurnoutPosition(p)
+	if err != nil {
+		return RouteTurnout{}, err
+	}
+	return RouteTurnout{Address: addr, Position: formatTurnoutPosition(pos)}, nil
+}
+
+// ---------- init ----------
+
+func init() {
+	// route definitions only change the context store, no connection needed
+	for _, c := range []*cobra.Command{routeAddCmd, routeListCmd, routeRmCmd} {
+		c.PersistentPreRunE = func(cmd *cobra.Command, args []string) error { return nil }
+		c.PersistentPostRun = func(cmd *cobra.Command, args []string) {}
+	}
+	routeCmd.AddCommand(
+		routeAddCmd,
+		routeListCmd,
+		routeRmCmd,
+		routeSetCmd,
+	)
+
+	routeAddCmd.Flags().StringArray("block", nil, "block that must be free to set the route (repeatable)")
+	routeSetCmd.Flags().Duration("spacing", DEFAULT_ROUTE_SPACING, "pause between two turnouts")
+	routeSetCmd.Flags().Bool("force", false, "set the route even if its blocks are not free")
+	routeSetCmd.Flags().DurationP("timeout", "t", DEFAULT_SCAN_TIMEOUT, "timeout of the block check")
+}
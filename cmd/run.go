@@ -0,0 +1,452 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trains-io/z21.go"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+)
+
+// run SCRIPT [ARG...]
+var runCmd = &cobra.Command{
+	Use:   "run SCRIPT [ARG...]",
+	Short: "Run a Starlark automation script",
+	Long: `Run a Starlark automation script on a single Z21 connection.
+
+The script uses the z21 module:
+
+  z21.args                          script arguments
+  z21.power_on(), z21.power_off()   switch the track power
+  z21.stop()                        emergency stop all locos
+  z21.loco_drive(addr, speed, forward=True)
+  z21.loco_stop(addr)               emergency stop a loco
+  z21.acc_set(addr, position)       position is "straight" or "diverging"
+  z21.route_set(name, spacing="200ms", force=False)
+  z21.send(header, data)            send a raw LAN message, data is a list of bytes
+  z21.subscribe(name)               subscribe to broadcasts, see "z21 sub ls"
+  z21.sleep(duration)               duration is a string ("500ms") or seconds
+  z21.block(name)                   state of a layout block: free, busy or unknown
+  z21.wait_block(name, state="busy", timeout=None)
+                                    wait for a block state, False on timeout
+  z21.next_event(timeout=None)      next event as dict, None on timeout
+
+Events have a "type" of track_power, sysdata, can or block.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		app := GetAppContext(cmd)
+		if app == nil || app.Conn == nil {
+			return fmt.Errorf("Z21 connection not initialized")
+		}
+
+		c, err := loadCurrentContext()
+		if err != nil {
+			return err
+		}
+
+		flags := z21.TRACK_UPDATES | z21.CAN_DETECTOR_UPDATES | z21.FEEDBACK_UPDATES | z21.LOCONET_DETECTOR_UPDATES
+		if err := subscribe(app.Conn, flags); err != nil {
+			return err
+		}
+
+		e := newScriptEngine(app, c)
+		return e.run(args[0], args[1:])
+	},
+}
+
+// scriptEngine runs a Starlark script on the connection of app. Events are
+// only read while the script waits for them.
+type scriptEngine struct {
+	app    *AppContext
+	ctx    *ContextInfo
+	layout *layoutModel
+	loaded bool
+	events []starlark.Value
+}
+
+func newScriptEngine(app *AppContext, c *ContextInfo) *scriptEngine {
+	return &scriptEngine{
+		app:    app,
+		ctx:    c,
+		layout: newLayoutModel(c),
+	}
+}
+
+func (e *scriptEngine) run(filename string, args []string) error {
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	thread := &starlark.Thread{
+		Name:  filename,
+		Print: func(_ *starlark.Thread, msg string) { fmt.Println(msg) },
+	}
+
+	predeclared := starlark.StringDict{
+		"z21": e.module(args),
+	}
+
+	opts := &syntax.FileOptions{
+		While:           true,
+		TopLevelControl: true,
+		GlobalReassign:  true,
+	}
+	if _, err := starlark.ExecFileOptions(opts, thread, filename, src, predeclared); err != nil {
+		if evalErr, ok := err.(*starlark.EvalError); ok {
+			return fmt.Errorf("%s", evalErr.Backtrace())
+		}
+		return err
+	}
+	return nil
+}
+
+func (e *scriptEngine) module(args []string) *starlarkstruct.Module {
+	argv := []starlark.Value{}
+	for _, a := range args {
+		argv = append(argv, starlark.String(a))
+	}
+
+	return &starlarkstruct.Module{
+		Name: "z21",
+		Members: starlark.StringDict{
+			"args":       starlark.NewList(argv),
+			"power_on":   starlark.NewBuiltin("power_on", e.powerOn),
+			"power_off":  starlark.NewBuiltin("power_off", e.powerOff),
+			"stop":       starlark.NewBuiltin("stop", e.stop),
+			"loco_drive": starlark.NewBuiltin("loco_drive", e.locoDrive),
+			"loco_stop":  starlark.NewBuiltin("loco_stop", e.locoStop),
+			"acc_set":    starlark.NewBuiltin("acc_set", e.accSet),
+			"route_set":  starlark.NewBuiltin("route_set", e.routeSet),
+			"send":       starlark.NewBuiltin("send", e.send),
+			"subscribe":  starlark.NewBuiltin("subscribe", e.subscribe),
+			"sleep":      starlark.NewBuiltin("sleep", e.sleep),
+			"block":      starlark.NewBuiltin("block", e.block),
+			"wait_block": starlark.NewBuiltin("wait_block", e.waitBlock),
+			"next_event": starlark.NewBuiltin("next_event", e.nextEvent),
+		},
+	}
+}
+
+// ---------- builtins ----------
+
+func (e *scriptEngine) powerOn(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs); err != nil {
+		return nil, err
+	}
+	return starlark.None, setTrackPower(e.app, true)
+}
+
+func (e *scriptEngine) powerOff(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs); err != nil {
+		return nil, err
+	}
+	return starlark.None, setTrackPower(e.app, false)
+}
+
+func (e *scriptEngine) stop(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs); err != nil {
+		return nil, err
+	}
+	_, err := Req(e.app.Conn, &z21.Stop{})
+	return starlark.None, err
+}
+
+func (e *scriptEngine) locoDrive(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var addr, speed int
+	forward := true
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "addr", &addr, "speed", &speed, "forward?", &forward); err != nil {
+		return nil, err
+	}
+	a, err := parseLocoAddress(strconv.Itoa(addr))
+	if err != nil {
+		return nil, err
+	}
+	if speed < 0 || speed > int(LOCO_MAX_SPEED) {
+		return nil, fmt.Errorf("invalid speed %d (0-%d)", speed, LOCO_MAX_SPEED)
+	}
+	return starlark.None, driveLoco(e.app, a, uint8(speed), forward)
+}
+
+func (e *scriptEngine) locoStop(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var addr int
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "addr", &addr); err != nil {
+		return nil, err
+	}
+	a, err := parseLocoAddress(strconv.Itoa(addr))
+	if err != nil {
+		return nil, err
+	}
+	_, err = Req(e.app.Conn, &locoEStop{Address: a})
+	return starlark.None, err
+}
+
+func (e *scriptEngine) accSet(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var addr int
+	var position string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "addr", &addr, "position", &position); err != nil {
+		return nil, err
+	}
+	a, err := parseTurnoutAddress(strconv.Itoa(addr))
+	if err != nil {
+		return nil, err
+	}
+	pos, err := parseTurnoutPosition(position)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.None, setTurnout(e.app, a, pos)
+}
+
+func (e *scriptEngine) routeSet(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	var spacing starlark.Value = starlark.String(DEFAULT_ROUTE_SPACING.String())
+	var force bool
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name, "spacing?", &spacing, "force?", &force); err != nil {
+		return nil, err
+	}
+	d, err := toDuration(spacing)
+	if err != nil {
+		return nil, err
+	}
+	route := findRoute(e.ctx, name)
+	if route == nil {
+		return nil, fmt.Errorf("route %q not found", name)
+	}
+	return starlark.None, setRoute(e.app, e.ctx, route, d, force, DEFAULT_SCAN_TIMEOUT)
+}
+
+func (e *scriptEngine) send(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var header int
+	var data *starlark.List
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "header", &header, "data", &data); err != nil {
+		return nil, err
+	}
+	msg := &rawMessage{Header: uint16(header)}
+	for i := 0; i < data.Len(); i++ {
+		v, err := starlark.AsInt32(data.Index(i))
+		if err != nil || v < 0 || v > 0xff {
+			return nil, fmt.Errorf("invalid byte %s", data.Index(i))
+		}
+		msg.Data = append(msg.Data, byte(v))
+	}
+	_, err := Req(e.app.Conn, msg)
+	return starlark.None, err
+}
+
+func (e *scriptEngine) subscribe(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name); err != nil {
+		return nil, err
+	}
+	flag, err := getSub(name)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.None, subscribe(e.app.Conn, flag)
+}
+
+func (e *scriptEngine) sleep(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var v starlark.Value
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "duration", &v); err != nil {
+		return nil, err
+	}
+	d, err := toDuration(v)
+	if err != nil {
+		return nil, err
+	}
+	time.Sleep(d)
+	return starlark.None, nil
+}
+
+func (e *scriptEngine) block(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name); err != nil {
+		return nil, err
+	}
+	block, err := e.findBlock(name)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.String(block.state().String()), nil
+}
+
+func (e *scriptEngine) waitBlock(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	state := BLOCK_BUSY.String()
+	var timeout starlark.Value = starlark.None
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name, "state?", &state, "timeout?", &timeout); err != nil {
+		return nil, err
+	}
+	block, err := e.findBlock(name)
+	if err != nil {
+		return nil, err
+	}
+	deadline, err := toDeadline(timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	for block.state().String() != state {
+		if !e.poll(deadline) {
+			return starlark.False, nil
+		}
+	}
+	return starlark.True, nil
+}
+
+func (e *scriptEngine) nextEvent(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var timeout starlark.Value = starlark.None
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "timeout?", &timeout); err != nil {
+		return nil, err
+	}
+	deadline, err := toDeadline(timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	for len(e.events) == 0 {
+		if !e.poll(deadline) {
+			return starlark.None, nil
+		}
+	}
+	ev := e.events[0]
+	e.events = e.events[1:]
+	return ev, nil
+}
+
+// ---------- events ----------
+
+// findBlock returns a layout block, the state of all blocks is requested on
+// first use.
+func (e *scriptEngine) findBlock(name string) (*layoutBlock, error) {
+	if !e.loaded {
+		if err := e.layout.load(e.app, DEFAULT_SCAN_TIMEOUT); err != nil {
+			return nil, err
+		}
+		e.loaded = true
+	}
+	for _, b := range e.layout.blocks {
+		if b.Name == name {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("block %q not mapped, see `z21 layout map`", name)
+}
+
+// poll waits for the next message until deadline and queues its events. A
+// zero deadline waits forever. It returns false on timeout.
+func (e *scriptEngine) poll(deadline time.Time) bool {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var changed []*layoutBlock
+	select {
+	case <-timeout:
+		return false
+	case ev := <-e.app.Conn.Events():
+		switch v := ev.(type) {
+		case *z21.TrackPower:
+			e.queue("track_power", starlark.StringDict{"on": starlark.Bool(v.On)})
+		case *z21.SysData:
+			e.queue("sysdata", starlark.StringDict{
+				"main_current":   starlark.MakeInt(int(v.MainCurrent)),
+				"prog_current":   starlark.MakeInt(int(v.ProgCurrent)),
+				"temperature":    starlark.MakeInt(int(v.Temperature)),
+				"supply_voltage": starlark.MakeInt(int(v.SupplyVoltage)),
+			})
+		case *z21.CanDetector:
+			if v.Type == z21.CANMessageTypeStatus {
+				e.queue("can", starlark.StringDict{
+					"netid":  starlark.MakeInt(int(v.NetworkID)),
+					"port":   starlark.MakeInt(int(v.Port) + 1),
+					"status": starlark.String(formatPortStatus(v.Value1)),
+					"busy":   starlark.Bool(isPortBusy(v.Value1)),
+				})
+			}
+			changed = e.layout.updateCanDetector(v)
+		}
+	case f := <-e.app.Frames:
+		changed = e.layout.updateFrame(&f)
+	}
+
+	for _, b := range changed {
+		locos := []starlark.Value{}
+		for _, l := range b.locos() {
+			locos = append(locos, starlark.MakeInt(int(l)))
+		}
+		e.queue("block", starlark.StringDict{
+			"name":  starlark.String(b.Name),
+			"state": starlark.String(b.state().String()),
+			"locos": starlark.NewList(locos),
+		})
+	}
+	return true
+}
+
+func (e *scriptEngine) queue(typ string, fields starlark.StringDict) {
+	d := starlark.NewDict(len(fields) + 1)
+	d.SetKey(starlark.String("type"), starlark.String(typ))
+	for _, k := range fields.Keys() {
+		d.SetKey(starlark.String(k), fields[k])
+	}
+	e.events = append(e.events, d)
+}
+
+// toDuration converts a duration string ("500ms") or seconds.
+func toDuration(v starlark.Value) (time.Duration, error) {
+	switch v := v.(type) {
+	case starlark.String:
+		return time.ParseDuration(string(v))
+	case starlark.Int, starlark.Float:
+		f, _ := starlark.AsFloat(v)
+		return time.Duration(f * float64(time.Second)), nil
+	default:
+		return 0, fmt.Errorf("invalid duration %s, expected a string or seconds", v)
+	}
+}
+
+// toDeadline converts a timeout to a deadline, None waits forever.
+func toDeadline(v starlark.Value) (time.Time, error) {
+	if v == starlark.None {
+		return time.Time{}, nil
+	}
+	d, err := toDuration(v)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Now().Add(d), nil
+}
+
+// ---------- messages ----------
+
+// rawMessage is a LAN message given by header and data.
+type rawMessage struct {
+	Header uint16
+	Data   []byte
+}
+
+func (m *rawMessage) Pack() ([]byte, error) {
+	return m.Data, nil
+}
+
+func (m *rawMessage) Unpack(data []byte) error {
+	return nil
+}
+
+func (m *rawMessage) EncapType() uint16 {
+	return m.Header
+}
+
+func (m *rawMessage) Key() (string, bool) {
+	return "", false
+}
@@ -0,0 +1,346 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trains-io/z21.go"
+)
+
+const (
+	DEFAULT_HTTP_ADDR string = ":8080"
+
+	// the discovery blocks all other requests
+	MAX_SCAN_TIMEOUT time.Duration = 5 * DEFAULT_SCAN_TIMEOUT
+)
+
+// serve [--http ADDR]
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve a JSON API on a single Z21 connection",
+	Long: `Serve a JSON API on a single Z21 connection, so that several tools share
+one Z21 session. Requests are executed one after the other.
+
+  GET  /status            track and system status
+  GET  /info              serial number, hardware and firmware
+  GET  /subscriptions     broadcast subscriptions of the session
+  GET  /can/devices       discover CAN detectors (?timeout=2s, at most 10s)
+  POST /power             {"on": true}
+  POST /loco/{addr}       {"speed": 40, "forward": true} or {"stop": true}
+  POST /accessory/{addr}  {"position": "straight"}
+
+The broadcasts of the Z21 are pushed to the clients of
+
+  GET  /events            Server-Sent Events
+  GET  /events/ws         WebSocket
+
+Select the event types with ?types=track,can. The server subscribes to the
+broadcasts given with --sub. Event types: ` + strings.Join(streamEventTypes, ", "),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		addr, _ := cmd.Flags().GetString("http")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		names, _ := cmd.Flags().GetStringSlice("sub")
+
+		if timeout <= 0 || timeout > MAX_SCAN_TIMEOUT {
+			return fmt.Errorf("invalid timeout %s (at most %s)", timeout, MAX_SCAN_TIMEOUT)
+		}
+
+		var flags uint32
+		for _, name := range names {
+			f, err := getSub(name)
+			if err != nil {
+				return err
+			}
+			flags |= f
+		}
+
+		app := GetAppContext(cmd)
+		if app == nil || app.Conn == nil {
+			return fmt.Errorf("Z21 connection not initialized")
+		}
+
+		if err := subscribe(app.Conn, flags); err != nil {
+			return err
+		}
+
+		s := &apiServer{app: app, timeout: timeout, hub: newEventHub()}
+		go s.pump()
+
+		fmt.Printf("Serving Z21 %q on http://%s\n", app.ContextName, addr)
+		return http.ListenAndServe(addr, s.routes())
+	},
+}
+
+// apiServer serializes the requests, as the replies of the Z21 are read from
+// the shared connection.
+type apiServer struct {
+	app     *AppContext
+	timeout time.Duration
+	mu      sync.Mutex // held while a request is served or a broadcast is read
+	hub     *eventHub
+}
+
+// apiError is returned by handlers for errors caused by the request.
+type apiError struct {
+	err error
+}
+
+func (e *apiError) Error() string {
+	return e.err.Error()
+}
+
+func badRequest(format string, args ...any) error {
+	return &apiError{fmt.Errorf(format, args...)}
+}
+
+type apiHandler func(r *http.Request) (any, error)
+
+func (s *apiServer) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("GET /status", s.handle(s.getStatus))
+	mux.Handle("GET /info", s.handle(s.getInfo))
+	mux.Handle("GET /subscriptions", s.handle(s.getSubscriptions))
+	mux.Handle("GET /can/devices", s.handle(s.getCanDevices))
+	mux.Handle("POST /power", s.handle(s.postPower))
+	mux.Handle("POST /loco/{addr}", s.handle(s.postLoco))
+	mux.Handle("POST /accessory/{addr}", s.handle(s.postAccessory))
+	mux.HandleFunc("GET /events", s.getEvents)
+	mux.HandleFunc("GET /events/ws", s.getEventsWebSocket)
+	return mux
+}
+
+// handle runs h with the connection locked and writes its result as JSON.
+// Errors of the request are reported with 400, errors of the Z21 with 502.
+func (s *apiServer) handle(h apiHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		v, err := h(r)
+		s.mu.Unlock()
+
+		status := http.StatusOK
+		if err != nil {
+			status = http.StatusBadGateway
+			var ae *apiError
+			if errors.As(err, &ae) {
+				status = http.StatusBadRequest
+			}
+			v = map[string]string{"error": err.Error()}
+		}
+		fmt.Printf("[API] %s %s %d\n", r.Method, r.URL.Path, status)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(v)
+	})
+}
+
+// ---------- handlers ----------
+
+type trackStatusReport struct {
+	EmergencyStop   bool `json:"emergency_stop"`
+	TrackVoltageOff bool `json:"track_voltage_off"`
+	ShortCircuit    bool `json:"short_circuit"`
+	ProgrammingMode bool `json:"programming_mode"`
+}
+
+func (s *apiServer) getStatus(r *http.Request) (any, error) {
+	st, err := getTrackStatus(s.app.Conn)
+	if err != nil {
+		return nil, err
+	}
+	data, err := getSystemStatus(s.app.Conn)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]any{
+		"track": trackStatusReport{
+			EmergencyStop:   st.Mask.Has(z21.EMERGENCY_STOP),
+			TrackVoltageOff: st.Mask.Has(z21.TRACK_VOLTAGE_OFF),
+			ShortCircuit:    st.Mask.Has(z21.SHORT_CIRCUIT),
+			ProgrammingMode: st.Mask.Has(z21.PROGRAMMING_MODE_ACTIVE),
+		},
+		"system": data,
+	}, nil
+}
+
+func (s *apiServer) getInfo(r *http.Request) (any, error) {
+	sn, err := Req(s.app.Conn, &z21.SerialNumber{})
+	if err != nil {
+		return nil, err
+	}
+	version, err := Req(s.app.Conn, &z21.Version{})
+	if err != nil {
+		return nil, err
+	}
+	hwinfo, err := Req(s.app.Conn, &z21.HwInfo{})
+	if err != nil {
+		return nil, err
+	}
+	scope, err := Req(s.app.Conn, &z21.Code{})
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]any{
+		"serial_number":   sn.SerialNumber,
+		"command_station": fmt.Sprintf("%s", version.CommandStationID),
+		"hardware":        fmt.Sprintf("%s", hwinfo.Hardware),
+		"firmware":        hwinfo.FirmwareVersion,
+		"xbus_version":    version.XBusProtoVersion,
+		"lock":            formatLockCode(scope.Code),
+	}, nil
+}
+
+type subscriptionReport struct {
+	Name       string `json:"name"`
+	Subscribed bool   `json:"subscribed"`
+}
+
+func (s *apiServer) getSubscriptions(r *http.Request) (any, error) {
+	f, err := Req(s.app.Conn, &z21.SubscribedBroadcastFlags{})
+	if err != nil {
+		return nil, err
+	}
+
+	report := []subscriptionReport{}
+	for _, sub := range subs {
+		report = append(report, subscriptionReport{
+			Name:       sub.name,
+			Subscribed: f.Flags&z21.Mask32(sub.flag) != 0,
+		})
+	}
+	return report, nil
+}
+
+func (s *apiServer) getCanDevices(r *http.Request) (any, error) {
+	timeout := s.timeout
+	if t := r.URL.Query().Get("timeout"); t != "" {
+		d, err := time.ParseDuration(t)
+		if err != nil || d <= 0 {
+			return nil, badRequest("invalid timeout %q", t)
+		}
+		timeout = min(d, MAX_SCAN_TIMEOUT)
+	}
+
+	// the pump does not read the broadcasts meanwhile
+	devices, err := discoverCanDevices(s.app, timeout, 0, nil, func(ev z21.Serializable) {
+		s.hub.publish(newStreamEvents(s.app, ev)...)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report := []*canDeviceReport{}
+	for _, d := range sortCanDevices(devices, "netid") {
+		report = append(report, d.report(s.app.CanBlocks))
+	}
+	return report, nil
+}
+
+func (s *apiServer) postPower(r *http.Request) (any, error) {
+	var req struct {
+		On *bool `json:"on"`
+	}
+	if err := decodeRequest(r, &req); err != nil {
+		return nil, err
+	}
+	if req.On == nil {
+		return nil, badRequest("missing \"on\"")
+	}
+
+	if err := setTrackPower(s.app, *req.On); err != nil {
+		return nil, err
+	}
+	return map[string]bool{"on": *req.On}, nil
+}
+
+func (s *apiServer) postLoco(r *http.Request) (any, error) {
+	addr, err := parseLocoAddress(r.PathValue("addr"))
+	if err != nil {
+		return nil, &apiError{err}
+	}
+
+	var req locoRequest
+	if err := decodeRequest(r, &req); err != nil {
+		return nil, err
+	}
+	return req.apply(s.app, addr)
+}
+
+// locoRequest drives or stops a loco, as sent to POST /loco/{addr} and the
+// loco command topic of the MQTT bridge.
+type locoRequest struct {
+	Speed   *int  `json:"speed"`
+	Forward *bool `json:"forward"`
+	Stop    bool  `json:"stop"`
+}
+
+func (req *locoRequest) apply(app *AppContext, addr uint16) (any, error) {
+	if req.Stop {
+		if _, err := Req(app.Conn, &locoEStop{Address: addr}); err != nil {
+			return nil, err
+		}
+		return map[string]any{"address": addr, "stopped": true}, nil
+	}
+
+	if req.Speed == nil {
+		return nil, badRequest("missing \"speed\" or \"stop\"")
+	}
+	if *req.Speed < 0 || *req.Speed > int(LOCO_MAX_SPEED) {
+		return nil, badRequest("invalid speed %d (0-%d)", *req.Speed, LOCO_MAX_SPEED)
+	}
+	forward := req.Forward == nil || *req.Forward
+
+	if err := driveLoco(app, addr, uint8(*req.Speed), forward); err != nil {
+		return nil, err
+	}
+	return map[string]any{"address": addr, "speed": *req.Speed, "forward": forward}, nil
+}
+
+func (s *apiServer) postAccessory(r *http.Request) (any, error) {
+	addr, err := parseTurnoutAddress(r.PathValue("addr"))
+	if err != nil {
+		return nil, &apiError{err}
+	}
+
+	var req struct {
+		Position string `json:"position"`
+	}
+	if err := decodeRequest(r, &req); err != nil {
+		return nil, err
+	}
+	pos, err := parseTurnoutPosition(req.Position)
+	if err != nil {
+		return nil, &apiError{err}
+	}
+
+	if err := setTurnout(s.app, addr, pos); err != nil {
+		return nil, err
+	}
+	return map[string]any{"address": addr, "position": formatTurnoutPosition(pos)}, nil
+}
+
+func decodeRequest(r *http.Request, v any) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return badRequest("invalid request body: %s", err)
+	}
+	return nil
+}
+
+// ---------- init ----------
+
+func init() {
+	serveCmd.Flags().String("http", DEFAULT_HTTP_ADDR, "address of the HTTP server")
+	serveCmd.Flags().DurationP("timeout", "t", DEFAULT_SCAN_TIMEOUT, "timeout of the CAN discovery")
+	serveCmd.Flags().StringSlice("sub", []string{"TRACK_UPDATES", "SYSTEM_UPDATES", "CAN_DETECTOR_UPDATES", "FEEDBACK_UPDATES"},
+		"broadcasts to subscribe for the event stream")
+}
@@ -0,0 +1,317 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/chzyer/readline"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/trains-io/z21.go"
+)
+
+var historyFile = filepath.Join(os.Getenv("HOME"), ".z21_history")
+
+// shell
+var shellCmd = &cobra.Command{
+	Use:   "shell",
+	Short: "Run subcommands interactively on a single connection",
+	Long: `Run subcommands interactively on a single Z21 connection. The prompt
+has a history and completes commands, flags, subscription names, route names
+and the NetIDs of the CAN devices seen so far with TAB.
+
+Broadcasts of the subscribed events (see "z21 sub add") are printed while
+the prompt waits for input. Besides the subcommands the shell knows:
+
+  help [CMD]       show the help of a subcommand
+  sleep DURATION   pause, e.g. "sleep 500ms"
+  exit, quit       leave the shell (or Ctrl-D)`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		app := GetAppContext(cmd)
+		if app == nil || app.Conn == nil {
+			return fmt.Errorf("Z21 connection not initialized")
+		}
+
+		s := &replShell{
+			app:  app,
+			rbus: map[uint8]*rbusData{},
+		}
+		rl, err := readline.NewEx(&readline.Config{
+			Prompt:          fmt.Sprintf("z21 (%s)> ", app.ContextName),
+			HistoryFile:     historyFile,
+			AutoComplete:    s,
+			InterruptPrompt: "^C",
+			EOFPrompt:       "exit",
+		})
+		if err != nil {
+			return err
+		}
+		defer rl.Close()
+		s.rl = rl
+
+		done := make(chan struct{})
+		defer close(done)
+		go s.watch(done)
+
+		return s.run()
+	},
+}
+
+// replShell runs the commands read by rl. The broadcasts are printed in the
+// background while no command runs, as commands read them as well.
+type replShell struct {
+	app  *AppContext
+	rl   *readline.Instance
+	mu   sync.Mutex // held while a command runs or a broadcast is printed
+	rbus map[uint8]*rbusData
+}
+
+func (s *replShell) run() error {
+	for {
+		line, err := s.rl.Readline()
+		if errors.Is(err, readline.ErrInterrupt) {
+			continue
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if line == "exit" || line == "quit" {
+			return nil
+		}
+
+		s.mu.Lock()
+		err = s.exec(line)
+		s.mu.Unlock()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		}
+	}
+}
+
+func (s *replShell) exec(line string) error {
+	args, err := splitCommandLine(line)
+	if err != nil {
+		return err
+	}
+
+	if args[0] == "help" {
+		c, _, err := rootCmd.Find(args[1:])
+		if err != nil {
+			return err
+		}
+		return c.Help()
+	}
+
+	return runBatchLine(s.app, line)
+}
+
+// watch prints the broadcasts until done is closed.
+func (s *replShell) watch(done <-chan struct{}) {
+	readBroadcasts(s.app, &s.mu, done,
+		func(ev z21.Serializable) {
+			if v, ok := ev.(*z21.CanDetector); ok {
+				s.app.CanDevices[v.NetworkID] = true
+				if v.Type >= CAN_MESSAGE_TYPE_RAILCOM_FIRST && v.Type <= CAN_MESSAGE_TYPE_RAILCOM_LAST {
+					s.addLocos(newCanLoco(v.Value1).Address, newCanLoco(v.Value2).Address)
+				}
+			}
+			s.rl.Clean()
+			printEvent(s.app, ev)
+			s.rl.Refresh()
+		},
+		func(f *z21.Frame) {
+			s.addLocos(frameLocoAddress(f))
+			s.rl.Clean()
+			printFrame(f, s.rbus)
+			s.rl.Refresh()
+		},
+	)
+}
+
+// addLocos records the loco addresses seen on the connection for the
+// completion, 0 is no loco.
+func (s *replShell) addLocos(addrs ...uint16) {
+	for _, addr := range addrs {
+		if addr != 0 {
+			s.app.Locos[addr] = true
+		}
+	}
+}
+
+// frameLocoAddress returns the loco of a loco info or RailCom broadcast, or 0.
+func frameLocoAddress(f *z21.Frame) uint16 {
+	switch f.Header {
+	case z21.LAN_X:
+		l := &locoInfo{}
+		if err := l.Unpack(f.Payload); err == nil {
+			return l.Address
+		}
+	case z21.LAN_RAILCOM_DATACHANGED:
+		d := &railcomData{}
+		if err := d.Unpack(f.Payload); err == nil {
+			return d.Address
+		}
+	}
+	return 0
+}
+
+// Do implements readline.AutoCompleter.
+func (s *replShell) Do(line []rune, pos int) ([][]rune, int) {
+	text := string(line[:pos])
+	words := strings.Fields(text)
+	word := ""
+	if len(words) > 0 && !strings.HasSuffix(text, " ") {
+		word = words[len(words)-1]
+		words = words[:len(words)-1]
+	}
+
+	// find the subcommand, the remaining words are its arguments
+	c := rootCmd
+	nargs := 0
+	for _, w := range words {
+		if strings.HasPrefix(w, "-") {
+			continue
+		}
+		if sub := findSubcommand(c, w); sub != nil && nargs == 0 {
+			c = sub
+			continue
+		}
+		nargs++
+	}
+
+	var candidates []string
+	switch {
+	case strings.HasPrefix(word, "-"):
+		candidates = flagNames(c)
+	case c.HasSubCommands() && nargs == 0:
+		for _, sub := range c.Commands() {
+			if sub.IsAvailableCommand() {
+				candidates = append(candidates, sub.Name())
+			}
+		}
+		if c == rootCmd {
+			candidates = append(candidates, "help", "sleep", "exit", "quit")
+		}
+	default:
+		candidates = s.completeArgs(c, nargs)
+	}
+
+	sort.Strings(candidates)
+	matches := [][]rune{}
+	for _, cand := range candidates {
+		if strings.HasPrefix(cand, word) {
+			matches = append(matches, []rune(cand[len(word):]+" "))
+		}
+	}
+	return matches, len([]rune(word))
+}
+
+// completeArgs returns the candidates of argument n of c.
+func (s *replShell) completeArgs(c *cobra.Command, n int) []string {
+	switch c {
+	case subAddCmd, subRmCmd:
+		names := []string{}
+		for _, sub := range subs {
+			names = append(names, sub.name)
+		}
+		return names
+	case routeSetCmd, routeRmCmd:
+		if n > 0 {
+			return nil
+		}
+		ctx, err := loadCurrentContext()
+		if err != nil {
+			return nil
+		}
+		names := []string{}
+		for _, r := range ctx.Routes {
+			names = append(names, r.Name)
+		}
+		return names
+	case canInfoCmd, canNameCmd, boosterStatusCmd, boosterPowerCmd:
+		if n > 0 {
+			return nil
+		}
+		return s.canNetIDs()
+	case locoDriveCmd, locoStopCmd, railcomGetCmd, decoderBackupCmd:
+		if n > 0 {
+			return nil
+		}
+		return s.locoAddresses()
+	case railcomWatchCmd:
+		return s.locoAddresses()
+	}
+	return nil
+}
+
+// locoAddresses returns the addresses of the locos seen on the connection,
+// reported by RailCom or by loco info broadcasts.
+func (s *replShell) locoAddresses() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	addrs := []string{}
+	for addr := range s.app.Locos {
+		addrs = append(addrs, strconv.Itoa(int(addr)))
+	}
+	return addrs
+}
+
+// canNetIDs returns the NetIDs seen on the connection and the NetIDs of the
+// saved CAN inventory.
+func (s *replShell) canNetIDs() []string {
+	netids := map[uint16]bool{}
+	if ctx, err := loadCurrentContext(); err == nil && ctx.CanInventory != nil {
+		for _, d := range ctx.CanInventory.Devices {
+			netids[d.NetworkID] = true
+		}
+	}
+
+	s.mu.Lock()
+	for id := range s.app.CanDevices {
+		netids[id] = true
+	}
+	s.mu.Unlock()
+
+	ids := []string{}
+	for id := range netids {
+		ids = append(ids, fmt.Sprintf("0x%04X", id))
+	}
+	return ids
+}
+
+func findSubcommand(c *cobra.Command, name string) *cobra.Command {
+	for _, sub := range c.Commands() {
+		if sub.Name() == name || sub.HasAlias(name) {
+			return sub
+		}
+	}
+	return nil
+}
+
+func flagNames(c *cobra.Command) []string {
+	names := []string{}
+	add := func(f *pflag.Flag) {
+		if !f.Hidden {
+			names = append(names, "--"+f.Name)
+		}
+	}
+	c.Flags().VisitAll(add)
+	c.InheritedFlags().VisitAll(add)
+	return names
+}
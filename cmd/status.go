@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math"
 	"os"
+	"time"
 
 	"github.com/jedib0t/go-pretty/v6/table"
 	"github.com/spf13/cobra"
@@ -93,23 +94,84 @@ var statusTrackCmd = &cobra.Command{
 	},
 }
 
-// system
+// system [--watch] [--interval DURATION] [--log FILE]
 var statusSystemCmd = &cobra.Command{
 	Use:   "system",
 	Short: "Show system status",
+	Long: `Show the system status. With --watch the status is polled every --interval
+and printed as one line per poll until interrupted.
+
+With --log FILE every poll is appended to a CSV file, e.g. to find the loco
+causing current spikes later on. The file is rotated to FILE.1 once it
+exceeds --max-size MB, keeping --max-files rotated files.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		watch, _ := cmd.Flags().GetBool("watch")
+		interval, _ := cmd.Flags().GetDuration("interval")
+		logPath, _ := cmd.Flags().GetString("log")
+		maxSize, _ := cmd.Flags().GetInt("max-size")
+		maxFiles, _ := cmd.Flags().GetInt("max-files")
+
+		if interval <= 0 {
+			return fmt.Errorf("invalid interval %s", interval)
+		}
+		if maxSize < 0 {
+			return fmt.Errorf("invalid max size %d MB", maxSize)
+		}
+		if maxFiles < 0 {
+			return fmt.Errorf("invalid max files %d", maxFiles)
+		}
+
 		app := GetAppContext(cmd)
 		if app == nil || app.Conn == nil {
 			return fmt.Errorf("Z21 connection not initialized")
 		}
 
-		data, err := getSystemStatus(app.Conn)
-		if err != nil {
-			return err
+		var sink *systemLog
+		if logPath != "" {
+			l, err := openSystemLog(logPath, int64(maxSize)<<20, maxFiles)
+			if err != nil {
+				return err
+			}
+			defer l.Close()
+			sink = l
 		}
 
-		printSystemStatus(data)
-		return nil
+		if !watch {
+			data, err := getSystemStatus(app.Conn)
+			if err != nil {
+				return err
+			}
+			if sink != nil {
+				if err := sink.Write(time.Now(), data); err != nil {
+					return err
+				}
+			}
+			printSystemStatus(data)
+			return nil
+		}
+
+		t := time.NewTicker(interval)
+		defer t.Stop()
+
+		// a missed poll does not end the watch
+		for ; ; <-t.C {
+			now := time.Now()
+			data, err := getSystemStatus(app.Conn)
+			if err == nil && data == nil {
+				err = fmt.Errorf("no system state received")
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+				continue
+			}
+
+			fmt.Printf("%s %s\n", now.Format("15:04:05"), formatSystemStatusLine(data))
+			if sink != nil {
+				if err := sink.Write(now, data); err != nil {
+					return err
+				}
+			}
+		}
 	},
 }
 
@@ -177,6 +239,18 @@ func printSystemStatus(d *z21.SysData) {
 	t.Render()
 }
 
+// formatSystemStatusLine formats the system status as a single line.
+func formatSystemStatusLine(d *z21.SysData) string {
+	return fmt.Sprintf(
+		"Main: %-5s Prog: %-5s Temp: %-5s Volt: %-5s (%-5s)",
+		fmt.Sprintf("%dmA", d.MainCurrent),
+		fmt.Sprintf("%dmA", d.ProgCurrent),
+		fmt.Sprintf("%d°C", d.Temperature),
+		fmt.Sprintf("%sV", mVToVoltString(d.SupplyVoltage)),
+		fmt.Sprintf("%sV", mVToVoltString(d.VccVoltage)),
+	)
+}
+
 func mVToVoltString(mV uint16) string {
 	volts := float64(mV) / 1000.0
 	voltsRounded := math.Round(volts*10) / 10
@@ -186,6 +260,12 @@ func mVToVoltString(mV uint16) string {
 // ---------- init ----------
 
 func init() {
+	statusSystemCmd.Flags().BoolP("watch", "w", false, "poll the system status until interrupted")
+	statusSystemCmd.Flags().DurationP("interval", "i", time.Second, "interval of the polls")
+	statusSystemCmd.Flags().String("log", "", "append the system status to a CSV file")
+	statusSystemCmd.Flags().Int("max-size", DEFAULT_LOG_MAX_SIZE, "rotate the log at this size in MB, 0 disables the rotation")
+	statusSystemCmd.Flags().Int("max-files", DEFAULT_LOG_MAX_FILES, "number of rotated logs to keep")
+
 	statusCmd.AddCommand(
 		statusTrackCmd,
 		statusSystemCmd,
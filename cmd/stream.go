@@ -0,0 +1,397 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"github.com/trains-io/z21.go"
+)
+
+const (
+	EVENT_TYPE_TRACK   string = "track"
+	EVENT_TYPE_SYSTEM  string = "system"
+	EVENT_TYPE_CAN     string = "can"
+	EVENT_TYPE_RBUS    string = "rbus"
+	EVENT_TYPE_RAILCOM string = "railcom"
+	EVENT_TYPE_CLOCK   string = "clock"
+	EVENT_TYPE_LOCONET string = "loconet"
+	EVENT_TYPE_LOCO    string = "loco"
+
+	STREAM_CLIENT_BUF_SIZE int           = 100
+	STREAM_KEEPALIVE       time.Duration = 30 * time.Second
+)
+
+var streamEventTypes = []string{
+	EVENT_TYPE_TRACK,
+	EVENT_TYPE_SYSTEM,
+	EVENT_TYPE_CAN,
+	EVENT_TYPE_RBUS,
+	EVENT_TYPE_RAILCOM,
+	EVENT_TYPE_CLOCK,
+	EVENT_TYPE_LOCONET,
+	EVENT_TYPE_LOCO,
+}
+
+// streamEvent is a broadcast of the Z21 as pushed to the clients.
+type streamEvent struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data"`
+}
+
+type streamTypes map[string]bool
+
+// parseStreamTypes parses a comma separated list of event types, an empty
+// list selects all types.
+func parseStreamTypes(s string) (streamTypes, error) {
+	types := streamTypes{}
+	for _, t := range strings.Split(s, ",") {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		known := false
+		for _, et := range streamEventTypes {
+			known = known || et == t
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown event type %q, expected %s", t, strings.Join(streamEventTypes, ", "))
+		}
+		types[t] = true
+	}
+	return types, nil
+}
+
+func (t streamTypes) match(ev *streamEvent) bool {
+	return len(t) == 0 || t[ev.Type]
+}
+
+// eventHub passes the events to all connected clients. Events are dropped
+// for clients that do not keep up.
+type eventHub struct {
+	mu      sync.Mutex
+	clients map[chan streamEvent]bool
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{clients: map[chan streamEvent]bool{}}
+}
+
+func (h *eventHub) add() chan streamEvent {
+	c := make(chan streamEvent, STREAM_CLIENT_BUF_SIZE)
+	h.mu.Lock()
+	h.clients[c] = true
+	h.mu.Unlock()
+	return c
+}
+
+func (h *eventHub) remove(c chan streamEvent) {
+	h.mu.Lock()
+	delete(h.clients, c)
+	h.mu.Unlock()
+}
+
+func (h *eventHub) publish(events ...streamEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.clients {
+		for _, ev := range events {
+			select {
+			case c <- ev:
+			default:
+			}
+		}
+	}
+}
+
+// ---------- handlers ----------
+
+// getEvents streams the events as Server-Sent Events.
+func (s *apiServer) getEvents(w http.ResponseWriter, r *http.Request) {
+	types, err := parseStreamTypes(r.URL.Query().Get("types"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	c := s.hub.add()
+	defer s.hub.remove(c)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(STREAM_KEEPALIVE)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case ev := <-c:
+			if !types.match(&ev) {
+				continue
+			}
+			data, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+		}
+		flusher.Flush()
+	}
+}
+
+// getEventsWebSocket streams the events as JSON messages over a WebSocket.
+// The client changes its event types by sending {"types": ["can", ...]}.
+func (s *apiServer) getEventsWebSocket(w http.ResponseWriter, r *http.Request) {
+	types, err := parseStreamTypes(r.URL.Query().Get("types"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.CloseNow()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	var mu sync.Mutex
+	go func() {
+		defer cancel()
+		for {
+			var req struct {
+				Types []string `json:"types"`
+			}
+			if err := wsjson.Read(ctx, conn, &req); err != nil {
+				return
+			}
+			t, err := parseStreamTypes(strings.Join(req.Types, ","))
+			if err != nil {
+				wsjson.Write(ctx, conn, map[string]string{"error": err.Error()})
+				continue
+			}
+			mu.Lock()
+			types = t
+			mu.Unlock()
+		}
+	}()
+
+	c := s.hub.add()
+	defer s.hub.remove(c)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-c:
+			mu.Lock()
+			match := types.match(&ev)
+			mu.Unlock()
+			if !match {
+				continue
+			}
+			if err := wsjson.Write(ctx, conn, ev); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// pump publishes the broadcasts to the clients.
+func (s *apiServer) pump() {
+	rbus := map[uint8]*rbusData{}
+	readBroadcasts(s.app, &s.mu, nil,
+		func(ev z21.Serializable) {
+			s.hub.publish(newStreamEvents(s.app, ev)...)
+		},
+		func(f *z21.Frame) {
+			s.hub.publish(frameStreamEvents(f, rbus)...)
+		},
+	)
+}
+
+// ---------- events ----------
+
+type canPortEventReport struct {
+	NetworkID uint16          `json:"netid"`
+	Port      int             `json:"port"`
+	Block     string          `json:"block,omitempty"`
+	Status    string          `json:"status,omitempty"`
+	Busy      bool            `json:"busy"`
+	Locos     []canLocoReport `json:"locos,omitempty"`
+}
+
+type rbusEventReport struct {
+	Module int  `json:"module"`
+	Input  int  `json:"input"`
+	Busy   bool `json:"busy"`
+}
+
+type railcomEventReport struct {
+	Address        uint16 `json:"address"`
+	ReceiveCounter uint32 `json:"receive_counter"`
+	ErrorCounter   uint16 `json:"error_counter"`
+	Speed          string `json:"speed"`
+	QoS            string `json:"qos"`
+}
+
+type locoEventReport struct {
+	Address       uint16 `json:"address"`
+	Speed         uint8  `json:"speed"`
+	SpeedSteps    int    `json:"speed_steps"`
+	Forward       bool   `json:"forward"`
+	EmergencyStop bool   `json:"emergency_stop"`
+	Functions     []int  `json:"functions"`
+}
+
+// newStreamEvents converts a broadcast decoded by z21.go.
+func newStreamEvents(app *AppContext, ev z21.Serializable) []streamEvent {
+	now := time.Now()
+	switch v := ev.(type) {
+	case *z21.TrackPower:
+		return []streamEvent{{EVENT_TYPE_TRACK, now, map[string]bool{"power": v.On}}}
+	case *z21.Status:
+		return []streamEvent{{EVENT_TYPE_TRACK, now, trackStatusReport{
+			EmergencyStop:   v.Mask.Has(z21.EMERGENCY_STOP),
+			TrackVoltageOff: v.Mask.Has(z21.TRACK_VOLTAGE_OFF),
+			ShortCircuit:    v.Mask.Has(z21.SHORT_CIRCUIT),
+			ProgrammingMode: v.Mask.Has(z21.PROGRAMMING_MODE_ACTIVE),
+		}}}
+	case *z21.SysData:
+		return []streamEvent{{EVENT_TYPE_SYSTEM, now, v}}
+	case *z21.CanDetector:
+		r := canPortEventReport{
+			NetworkID: v.NetworkID,
+			Port:      int(v.Port) + 1,
+			Block:     app.CanBlocks[formatCanPort(v.NetworkID, v.Port)],
+		}
+		switch {
+		case v.Type == z21.CANMessageTypeStatus:
+			r.Status = formatPortStatus(v.Value1)
+			r.Busy = isPortBusy(v.Value1)
+		case v.Type >= CAN_MESSAGE_TYPE_RAILCOM_FIRST && v.Type <= CAN_MESSAGE_TYPE_RAILCOM_LAST:
+			r.Busy = true
+			for _, l := range []canLoco{newCanLoco(v.Value1), newCanLoco(v.Value2)} {
+				if l.Address != 0 {
+					r.Locos = append(r.Locos, canLocoReport{Address: l.Address, Direction: l.direction()})
+				}
+			}
+			if len(r.Locos) == 0 {
+				return nil
+			}
+		default:
+			return nil
+		}
+		return []streamEvent{{EVENT_TYPE_CAN, now, r}}
+	}
+	return nil
+}
+
+// frameStreamEvents converts a broadcast frame not decoded by z21.go. rbus
+// holds the last state of the R-Bus groups to report the changed inputs only.
+func frameStreamEvents(f *z21.Frame, rbus map[uint8]*rbusData) []streamEvent {
+	now := time.Now()
+	if isLocoNetFrame(f) {
+		return []streamEvent{{EVENT_TYPE_LOCONET, now, map[string]string{"message": formatLocoNet(f.Payload)}}}
+	}
+
+	switch {
+	case isXFrame(f, z21.LAN_X_61, z21.LAN_X_BC_TRACK_SHORT_CIRCUIT):
+		return []streamEvent{{EVENT_TYPE_TRACK, now, map[string]bool{"short_circuit": true}}}
+	case f.Header == z21.LAN_X && len(f.Payload) > 0 && f.Payload[0] == z21.LAN_X_LOCO_INFO:
+		l := &locoInfo{}
+		if err := l.Unpack(f.Payload); err != nil {
+			return nil
+		}
+		return []streamEvent{{EVENT_TYPE_LOCO, now, locoEventReport{
+			Address:       l.Address,
+			Speed:         l.Speed,
+			SpeedSteps:    l.SpeedSteps,
+			Forward:       l.Forward,
+			EmergencyStop: l.EmergencyStop,
+			Functions:     l.functions(),
+		}}}
+	case f.Header == z21.LAN_RMBUS_DATACHANGED:
+		d := &rbusData{}
+		if err := d.Unpack(f.Payload); err != nil {
+			return nil
+		}
+		prev := rbus[d.Group]
+		rbus[d.Group] = d
+
+		events := []streamEvent{}
+		for i, status := range d.Status {
+			var old uint8
+			if prev != nil {
+				old = prev.Status[i]
+			}
+			for input := 0; input < RBUS_MODULE_INPUTS; input++ {
+				if (status^old)&(1<<input) == 0 {
+					continue
+				}
+				events = append(events, streamEvent{EVENT_TYPE_RBUS, now, rbusEventReport{
+					Module: d.module(i),
+					Input:  input + 1,
+					Busy:   status&(1<<input) != 0,
+				}})
+			}
+		}
+		return events
+	case f.Header == z21.LAN_RAILCOM_DATACHANGED:
+		d := &railcomData{}
+		if err := d.Unpack(f.Payload); err != nil {
+			return nil
+		}
+		return []streamEvent{{EVENT_TYPE_RAILCOM, now, railcomEventReport{
+			Address:        d.Address,
+			ReceiveCounter: d.ReceiveCounter,
+			ErrorCounter:   d.ErrorCounter,
+			Speed:          formatRailcomSpeed(d),
+			QoS:            formatRailcomQos(d),
+		}}}
+	case f.Header == z21.LAN_FAST_CLOCK_DATA:
+		c := &fastClockData{}
+		if err := c.Unpack(f.Payload); err != nil {
+			return nil
+		}
+		return []streamEvent{{EVENT_TYPE_CLOCK, now, map[string]any{
+			"hour":    c.Hour,
+			"minute":  c.Minute,
+			"second":  c.Second,
+			"rate":    c.Rate,
+			"stopped": c.Stopped,
+			"text":    formatFastClock(c),
+		}}}
+	case f.Header == z21.LAN_LOCONET_DETECTOR:
+		d := &loconetDetector{}
+		if err := d.Unpack(f.Payload); err != nil {
+			return nil
+		}
+		return []streamEvent{{EVENT_TYPE_LOCONET, now, map[string]any{
+			"address": d.Address,
+			"type":    formatDetectorType(d.Type),
+			"info":    formatDetectorInfo(d),
+		}}}
+	}
+	return nil
+}
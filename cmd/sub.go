@@ -249,6 +249,22 @@ func getSub(name string) (uint32, error) {
 	return 0, fmt.Errorf("unsupported subscription %q", name)
 }
 
+// subscribe adds flags to the broadcast flags of the current session.
+func subscribe(conn *z21.Conn, flags uint32) error {
+	f, err := Req(conn, &z21.SubscribedBroadcastFlags{})
+	if err != nil {
+		return err
+	}
+
+	subscription := f.Flags | z21.Mask32(flags)
+	if subscription == f.Flags {
+		return nil
+	}
+
+	_, err = Req(conn, &z21.BroadcastFlags{Flags: subscription})
+	return err
+}
+
 // ---------- init ----------
 
 func init() {